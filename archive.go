@@ -0,0 +1,417 @@
+package tinygit
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+const (
+	cacheDirName      = "cache"
+	archiveCacheTTL   = 24 * time.Hour
+	downloadRetries   = 5
+	partialFileSuffix = ".part"
+)
+
+// ArchiveInfo identifica um zip gerado pelo servidor e guardado em cache. O ID
+// é derivado do conteúdo, então a mesma atualização sempre gera o mesmo ID e o
+// cliente pode retomar o download com requisições Range.
+type ArchiveInfo struct {
+	ID         string   `json:"id"`
+	Size       int64    `json:"size"`
+	Hash       string   `json:"hash"`
+	Extensions []string `json:"extensions,omitempty"`
+}
+
+var errArchiveUnsupported = errors.New("servidor não suporta download de arquivos em cache")
+
+// Escreve o manifesto, a versão assinada (se houver) e os arquivos do
+// manifesto no zip, parando se ctx for cancelado
+func writeArchive(ctx context.Context, zipWriter *zip.Writer, rootPath string, m *Manifest, release []byte) error {
+	err := writeManifestToZip(zipWriter, m)
+	if err != nil {
+		return err
+	}
+
+	if release != nil {
+		err = writeReleaseToZip(zipWriter, release)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, entry := range m.files() {
+		path := filepath.Join(rootPath, entry.Path)
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		err = addFileToZip(ctx, zipWriter, path, entry.Path, info)
+		if err != nil {
+			return err
+		}
+	}
+
+	return zipWriter.Close()
+}
+
+// Gera (ou reaproveita) o zip em .tinygit/cache para o manifesto informado
+func prepareArchive(ctx context.Context, rootPath string, m *Manifest, release []byte) (*ArchiveInfo, error) {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+
+	key := sha1.New()
+	key.Write(b)
+	key.Write(release)
+	id := hex.EncodeToString(key.Sum(nil))
+
+	cacheDir := filepath.Join(rootPath, versionDirName, cacheDirName)
+	err = os.MkdirAll(cacheDir, 0700)
+	if err != nil {
+		return nil, err
+	}
+	removeExpiredArchives(cacheDir)
+
+	archivePath := filepath.Join(cacheDir, id+".zip")
+	if _, err := os.Stat(archivePath); os.IsNotExist(err) {
+		tempFile, err := os.CreateTemp(cacheDir, id+"-*.tmp")
+		if err != nil {
+			return nil, err
+		}
+		defer os.Remove(tempFile.Name())
+
+		err = writeArchive(ctx, zip.NewWriter(tempFile), rootPath, m, release)
+		if err != nil {
+			tempFile.Close()
+			return nil, err
+		}
+		err = tempFile.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		err = os.Rename(tempFile.Name(), archivePath)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	info, err := os.Stat(archivePath)
+	if err != nil {
+		return nil, err
+	}
+
+	hash, err := calculateContentHash(archivePath)
+	if err != nil {
+		return nil, err
+	}
+
+	// Mantém o arquivo em uso fora da limpeza do cache
+	now := time.Now()
+	os.Chtimes(archivePath, now, now)
+
+	return &ArchiveInfo{ID: id, Size: info.Size(), Hash: hash}, nil
+}
+
+// Remove zips do cache que não foram usados recentemente
+func removeExpiredArchives(cacheDir string) {
+	entries, err := os.ReadDir(cacheDir)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || time.Since(info.ModTime()) < archiveCacheTTL {
+			continue
+		}
+		os.Remove(filepath.Join(cacheDir, entry.Name()))
+	}
+}
+
+func writeArchiveInfo(w http.ResponseWriter, info *ArchiveInfo) {
+	b, err := json.Marshal(info)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(b)
+}
+
+// PullArchiveHandler recebe a árvore do cliente, como PullHandler, mas em vez
+// de enviar o zip gera um arquivo em cache e retorna seu ArchiveInfo
+func PullArchiveHandler(w http.ResponseWriter, r *http.Request, rootPath string, n Node) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Método não permitido", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var tree Node
+	err := json.NewDecoder(r.Body).Decode(&tree)
+	if err != nil {
+		http.Error(w, "Árvore inválida", http.StatusBadRequest)
+		return
+	}
+
+	// O zip contém todos os arquivos, inclusive os renomeados
+	c := CompareTrees(&tree, &n)
+	c.foldRenames()
+
+	m, err := newManifest(rootPath, n.Hash, c)
+	if err != nil {
+		http.Error(w, "Erro ao gerar manifesto", http.StatusInternalServerError)
+		return
+	}
+
+	release, err := readSignedRelease(rootPath)
+	if err != nil {
+		http.Error(w, "Erro ao ler a versão assinada", http.StatusInternalServerError)
+		return
+	}
+
+	info, err := prepareArchive(r.Context(), rootPath, m, release)
+	if err != nil {
+		http.Error(w, "Erro ao gerar arquivo", http.StatusInternalServerError)
+		return
+	}
+
+	writeArchiveInfo(w, info)
+}
+
+// CloneArchiveHandler gera em cache o zip com todos os arquivos versionados e
+// retorna seu ArchiveInfo
+func CloneArchiveHandler(w http.ResponseWriter, r *http.Request, rootPath string) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Método não permitido", http.StatusMethodNotAllowed)
+		return
+	}
+
+	v, err := decompressVersionFile(rootPath)
+	if err != nil {
+		http.Error(w, "Erro ao ler a árvore salva", http.StatusInternalServerError)
+		return
+	}
+
+	m, err := cloneManifest(rootPath, v)
+	if err != nil {
+		http.Error(w, "Erro ao percorrer diretório", http.StatusInternalServerError)
+		return
+	}
+
+	release, err := readSignedRelease(rootPath)
+	if err != nil {
+		http.Error(w, "Erro ao ler a versão assinada", http.StatusInternalServerError)
+		return
+	}
+
+	info, err := prepareArchive(r.Context(), rootPath, m, release)
+	if err != nil {
+		http.Error(w, "Erro ao gerar arquivo", http.StatusInternalServerError)
+		return
+	}
+	info.Extensions = v.ExtensionsToGenerateVersion
+
+	writeArchiveInfo(w, info)
+}
+
+// ArchiveHandler envia um zip do cache, com suporte a requisições Range
+func ArchiveHandler(w http.ResponseWriter, r *http.Request, rootPath string) {
+	id := r.URL.Query().Get("id")
+	if _, err := hex.DecodeString(id); err != nil || len(id) != sha1.Size*2 {
+		http.Error(w, "Identificador inválido", http.StatusBadRequest)
+		return
+	}
+
+	file, err := os.Open(filepath.Join(rootPath, versionDirName, cacheDirName, id+".zip"))
+	if err != nil {
+		http.Error(w, "Arquivo não encontrado", http.StatusNotFound)
+		return
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("ETag", `"`+id+`"`)
+	http.ServeContent(w, r, id+".zip", info.ModTime(), file)
+}
+
+// Solicita ao servidor a geração de um zip em cache
+func requestArchive(method, path string, body []byte, serverUrl string, parameters map[string]string, t *transfer) (*ArchiveInfo, error) {
+	u, err := parseUrlParameter(serverUrl, path, parameters)
+	if err != nil {
+		return nil, err
+	}
+
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+
+	req, err := http.NewRequest(method, u, reader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	// Gerar o arquivo não altera o repositório
+	resp, err := t.doArchive(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusMethodNotAllowed {
+		return nil, errArchiveUnsupported
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, newHTTPError("preparar o arquivo", resp)
+	}
+
+	var info ArchiveInfo
+	err = json.NewDecoder(resp.Body).Decode(&info)
+	if err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// Baixa o zip em .tinygit/tmp, retomando downloads parciais (inclusive de
+// execuções anteriores) com requisições Range. Retorna o caminho do zip
+// completo e verificado.
+func downloadArchive(rootPath string, info *ArchiveInfo, serverUrl string, parameters map[string]string, t *transfer) (string, error) {
+	tmpDir := filepath.Join(rootPath, versionDirName, tmpDirName)
+	err := os.MkdirAll(tmpDir, 0700)
+	if err != nil {
+		return "", err
+	}
+
+	archivePath := filepath.Join(tmpDir, info.ID+".zip")
+	partPath := archivePath + partialFileSuffix
+
+	t.progress.start(PhaseDownload, info.Size, 1)
+	failures := 0
+	for {
+		err = downloadArchiveRange(partPath, info, serverUrl, parameters, t)
+		if err == nil {
+			break
+		}
+		if t.ctx.Err() != nil {
+			return "", t.ctx.Err()
+		}
+		failures++
+		if failures > downloadRetries {
+			return "", fmt.Errorf("erro ao baixar o arquivo: %w", err)
+		}
+		logger().Warn("Erro ao baixar o arquivo, tentando novamente", "erro", err)
+		if err := t.sleep(time.Duration(failures) * time.Second); err != nil {
+			return "", err
+		}
+	}
+
+	hash, err := calculateContentHash(partPath)
+	if err != nil {
+		return "", err
+	}
+	if !CompareHashes(hash, info.Hash) {
+		os.Remove(partPath)
+		return "", &IntegrityError{Path: info.ID + ".zip", Field: "conteúdo", Expected: info.Hash, Actual: hash}
+	}
+
+	err = os.Rename(partPath, archivePath)
+	if err != nil {
+		return "", err
+	}
+	t.progress.add(0, 1)
+	t.progress.finish()
+	return archivePath, nil
+}
+
+// Continua o download a partir do tamanho atual do arquivo parcial
+func downloadArchiveRange(partPath string, info *ArchiveInfo, serverUrl string, parameters map[string]string, t *transfer) error {
+	part, err := os.OpenFile(partPath, os.O_WRONLY|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	defer part.Close()
+
+	offset, err := part.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	if offset == info.Size {
+		t.progress.setBytes(offset)
+		return nil
+	}
+	if offset > info.Size {
+		offset = 0
+	}
+
+	u, err := parseUrlParameter(serverUrl, "archive", withParameters(parameters, "id", info.ID))
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	if offset > 0 {
+		logger().Info("Retomando download", "bytes", offset, "total", info.Size)
+		req.Header.Set("Range", "bytes="+strconv.FormatInt(offset, 10)+"-")
+		req.Header.Set("If-Range", `"`+info.ID+`"`)
+	}
+
+	resp, err := t.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+	case http.StatusOK:
+		// O servidor enviou o arquivo completo
+		offset = 0
+	default:
+		return newHTTPError("baixar o arquivo", resp)
+	}
+
+	err = part.Truncate(offset)
+	if err != nil {
+		return err
+	}
+	_, err = part.Seek(offset, io.SeekStart)
+	if err != nil {
+		return err
+	}
+
+	t.progress.setBytes(offset)
+	n, err := io.Copy(part, t.progress.reader(t.reader(resp.Body)))
+	if err != nil {
+		return err
+	}
+	if offset+n != info.Size {
+		return fmt.Errorf("download incompleto: %d de %d bytes", offset+n, info.Size)
+	}
+	return nil
+}
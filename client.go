@@ -0,0 +1,273 @@
+package tinygit
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	defaultConnectTimeout = 10 * time.Second
+	defaultReadTimeout    = 60 * time.Second
+	defaultArchiveTimeout = 30 * time.Minute
+	defaultMaxRetries     = 4
+	defaultRetryBackoff   = 500 * time.Millisecond
+	defaultMaxBackoff     = 30 * time.Second
+	// Espera máxima aceita em um cabeçalho Retry-After
+	maxRetryAfter = 5 * time.Minute
+)
+
+// ClientOptions configura as requisições feitas ao servidor. Campos zerados
+// usam os valores padrão.
+type ClientOptions struct {
+	// Tempo máximo para estabelecer a conexão
+	ConnectTimeout time.Duration
+	// Tempo máximo aguardando a resposta ou sem receber dados dela
+	ReadTimeout time.Duration
+	// Tempo máximo aguardando o servidor começar a responder em /clone,
+	// /pull e nos arquivos de /clone/archive e /pull/archive, que são
+	// gerados antes da resposta
+	ArchiveTimeout time.Duration
+	// Número de novas tentativas de requisições idempotentes. Negativo
+	// desativa as novas tentativas.
+	MaxRetries int
+	// Espera antes da primeira nova tentativa, dobrada a cada falha
+	RetryBackoff time.Duration
+	// Espera máxima entre duas tentativas
+	MaxBackoff time.Duration
+}
+
+func (o ClientOptions) withDefaults() ClientOptions {
+	if o.ConnectTimeout <= 0 {
+		o.ConnectTimeout = defaultConnectTimeout
+	}
+	if o.ReadTimeout <= 0 {
+		o.ReadTimeout = defaultReadTimeout
+	}
+	if o.ArchiveTimeout <= 0 {
+		o.ArchiveTimeout = defaultArchiveTimeout
+	}
+	if o.MaxRetries == 0 {
+		o.MaxRetries = defaultMaxRetries
+	} else if o.MaxRetries < 0 {
+		o.MaxRetries = 0
+	}
+	if o.RetryBackoff <= 0 {
+		o.RetryBackoff = defaultRetryBackoff
+	}
+	if o.MaxBackoff <= 0 {
+		o.MaxBackoff = defaultMaxBackoff
+	}
+	return o
+}
+
+// Cliente HTTP usado em todas as requisições ao servidor
+type httpClient struct {
+	client *http.Client
+	opts   ClientOptions
+}
+
+func newHTTPClient(opts ClientOptions) *httpClient {
+	opts = opts.withDefaults()
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = (&net.Dialer{
+		Timeout:   opts.ConnectTimeout,
+		KeepAlive: 30 * time.Second,
+	}).DialContext
+	transport.TLSHandshakeTimeout = opts.ConnectTimeout
+	// A espera pelo cabeçalho é limitada por requisição em send, já que os
+	// arquivos zip demoram mais que as demais respostas
+
+	return &httpClient{client: &http.Client{Transport: transport}, opts: opts}
+}
+
+func isIdempotent(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+func isRetryableStatus(code int) bool {
+	switch code {
+	case http.StatusRequestTimeout, http.StatusTooManyRequests, http.StatusInternalServerError,
+		http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// Envia a requisição, repetindo-a apenas se o método for idempotente
+func (c *httpClient) do(req *http.Request) (*http.Response, error) {
+	return c.doWith(req, isIdempotent(req), c.opts.ReadTimeout)
+}
+
+// Envia uma requisição POST que apenas consulta o servidor, como a
+// comparação de árvores, e por isso pode ser repetida
+func (c *httpClient) doSafe(req *http.Request) (*http.Response, error) {
+	return c.doWith(req, true, c.opts.ReadTimeout)
+}
+
+// Envia uma requisição que apenas consulta o servidor e cuja resposta só
+// começa depois de gerado o arquivo zip, aguardando até ArchiveTimeout
+func (c *httpClient) doArchive(req *http.Request) (*http.Response, error) {
+	return c.doWith(req, true, c.opts.ArchiveTimeout)
+}
+
+// Envia a requisição. Se retry for verdadeiro e o corpo puder ser reenviado,
+// ela é repetida em caso de erro de rede ou de status temporário, aguardando
+// o Retry-After do servidor ou um intervalo exponencial com variação
+// aleatória. A resposta deve começar em até headerTimeout, e o corpo é
+// cancelado se ficar ReadTimeout sem dados.
+func (c *httpClient) doWith(req *http.Request, retry bool, headerTimeout time.Duration) (*http.Response, error) {
+	retry = retry && (req.Body == nil || req.GetBody != nil)
+
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req.Body = body
+		}
+
+		resp, err := c.send(req, headerTimeout)
+
+		if !retry || attempt >= c.opts.MaxRetries || req.Context().Err() != nil {
+			return resp, err
+		}
+		if err == nil && !isRetryableStatus(resp.StatusCode) {
+			return resp, nil
+		}
+
+		delay := c.backoff(attempt)
+		if err == nil {
+			if after, ok := retryAfter(resp); ok {
+				delay = after
+			}
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+			resp.Body.Close()
+		}
+
+		select {
+		case <-time.After(delay):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+	}
+}
+
+func (c *httpClient) send(req *http.Request, headerTimeout time.Duration) (*http.Response, error) {
+	ctx, cancel := context.WithCancel(req.Context())
+	timer := time.AfterFunc(headerTimeout, cancel)
+	resp, err := c.client.Do(req.WithContext(ctx))
+	if !timer.Stop() {
+		// O prazo terminou antes da resposta, ou logo depois dela, já
+		// cancelando o corpo
+		if err == nil {
+			resp.Body.Close()
+		}
+		cancel()
+		return nil, errResponseTimeout
+	}
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	resp.Body = newIdleTimeoutBody(resp.Body, c.opts.ReadTimeout, cancel)
+	return resp, nil
+}
+
+// Intervalo exponencial com variação aleatória entre metade e o total
+func (c *httpClient) backoff(attempt int) time.Duration {
+	d := c.opts.RetryBackoff << attempt
+	if d <= 0 || d > c.opts.MaxBackoff {
+		d = c.opts.MaxBackoff
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// Lê o cabeçalho Retry-After, em segundos ou como data
+func retryAfter(resp *http.Response) (time.Duration, bool) {
+	value := resp.Header.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+
+	var d time.Duration
+	if seconds, err := strconv.Atoi(value); err == nil {
+		d = time.Duration(seconds) * time.Second
+	} else if date, err := http.ParseTime(value); err == nil {
+		d = time.Until(date)
+	} else {
+		return 0, false
+	}
+
+	if d < 0 {
+		d = 0
+	}
+	if d > maxRetryAfter {
+		d = maxRetryAfter
+	}
+	return d, true
+}
+
+var (
+	errReadTimeout     = errors.New("tempo limite de leitura excedido")
+	errResponseTimeout = errors.New("tempo limite aguardando a resposta excedido")
+)
+
+// Corpo de resposta que cancela a requisição se ficar timeout sem receber
+// dados
+type idleTimeoutBody struct {
+	rc      io.ReadCloser
+	timeout time.Duration
+	timer   *time.Timer
+	cancel  context.CancelFunc
+
+	mu      sync.Mutex
+	expired bool
+}
+
+func newIdleTimeoutBody(rc io.ReadCloser, timeout time.Duration, cancel context.CancelFunc) *idleTimeoutBody {
+	b := &idleTimeoutBody{rc: rc, timeout: timeout, cancel: cancel}
+	b.timer = time.AfterFunc(timeout, func() {
+		b.mu.Lock()
+		b.expired = true
+		b.mu.Unlock()
+		cancel()
+	})
+	return b
+}
+
+func (b *idleTimeoutBody) Read(p []byte) (int, error) {
+	n, err := b.rc.Read(p)
+	if n > 0 {
+		b.timer.Reset(b.timeout)
+	}
+	if err != nil && err != io.EOF {
+		b.mu.Lock()
+		expired := b.expired
+		b.mu.Unlock()
+		if expired {
+			return n, errReadTimeout
+		}
+	}
+	return n, err
+}
+
+func (b *idleTimeoutBody) Close() error {
+	b.timer.Stop()
+	err := b.rc.Close()
+	b.cancel()
+	return err
+}
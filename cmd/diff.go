@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/leonardodf95/tinygit"
+)
+
+// Cada arquivo com a alteração, os tamanhos, os hashes e o diff de texto,
+// quando houver
+func printDiff(w io.Writer, d *tinygit.Diff) {
+	for _, f := range d.Files {
+		fmt.Fprintf(w, "%s %s (%s, %s)\n", stateLetters[f.State], f.Path, formatSizes(f.FileStatus), formatDelta(f.SizeDelta))
+		fmt.Fprintf(w, "hash %s -> %s\n", shortHash(f.OldHash), shortHash(f.NewHash))
+		if f.Text != "" {
+			io.WriteString(w, f.Text)
+		}
+	}
+}
+
+// Um arquivo por linha com a diferença de tamanho, seguido do total
+func printDiffStat(w io.Writer, d *tinygit.Diff) {
+	width := 0
+	for _, f := range d.Files {
+		if len(f.Path) > width {
+			width = len(f.Path)
+		}
+	}
+
+	var total int64
+	counts := map[tinygit.FileState]int{}
+	for _, f := range d.Files {
+		fmt.Fprintf(w, " %-*s | %s %s\n", width, f.Path, stateLetters[f.State], formatDelta(f.SizeDelta))
+		total += f.SizeDelta
+		counts[f.State]++
+	}
+	fmt.Fprintf(w, " %d arquivo(s) modificado(s), %d adicionado(s), %d removido(s), %s\n",
+		counts[tinygit.FileModified], counts[tinygit.FileAdded], counts[tinygit.FileRemoved], formatDelta(total))
+}
+
+func printDiffNameOnly(w io.Writer, d *tinygit.Diff) {
+	for _, f := range d.Files {
+		fmt.Fprintln(w, f.Path)
+	}
+}
+
+func printDiffJSON(w io.Writer, d *tinygit.Diff) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	// Mantém legíveis os diffs de XML
+	enc.SetEscapeHTML(false)
+	return enc.Encode(d)
+}
+
+func formatDelta(n int64) string {
+	if n < 0 {
+		return "-" + formatBytes(-n)
+	}
+	return "+" + formatBytes(n)
+}
+
+func shortHash(hash string) string {
+	if hash == "" {
+		return "-"
+	}
+	if len(hash) > 8 {
+		return hash[:8]
+	}
+	return hash
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+// Níveis de mensagem, na mesma ordem de log/slog
+const (
+	levelDebug = iota
+	levelInfo
+	levelWarn
+	levelError
+)
+
+var levelPrefixes = map[int]string{
+	levelDebug: "debug: ",
+	levelWarn:  "aviso: ",
+	levelError: "erro: ",
+}
+
+// Logger do terminal: escreve as mensagens a partir do nível mínimo, uma por
+// linha, seguidas dos pares chave=valor
+type cliLogger struct {
+	mu    sync.Mutex
+	out   io.Writer
+	level int
+}
+
+func newCLILogger(out io.Writer, verbose, quiet bool) *cliLogger {
+	level := levelInfo
+	if verbose {
+		level = levelDebug
+	} else if quiet {
+		level = levelError
+	}
+	return &cliLogger{out: out, level: level}
+}
+
+func (l *cliLogger) Debug(msg string, args ...any) { l.log(levelDebug, msg, args) }
+func (l *cliLogger) Info(msg string, args ...any)  { l.log(levelInfo, msg, args) }
+func (l *cliLogger) Warn(msg string, args ...any)  { l.log(levelWarn, msg, args) }
+func (l *cliLogger) Error(msg string, args ...any) { l.log(levelError, msg, args) }
+
+func (l *cliLogger) log(level int, msg string, args []any) {
+	if level < l.level {
+		return
+	}
+
+	var b strings.Builder
+	b.WriteString(levelPrefixes[level])
+	b.WriteString(msg)
+	for i := 0; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			fmt.Fprintf(&b, " %v", args[i])
+			break
+		}
+		value := fmt.Sprint(args[i+1])
+		if strings.ContainsAny(value, " \t\"=") {
+			value = fmt.Sprintf("%q", value)
+		}
+		fmt.Fprintf(&b, " %v=%s", args[i], value)
+	}
+	b.WriteByte('\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	io.WriteString(l.out, b.String())
+}
@@ -1,7 +1,15 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/leonardodf95/tinygit"
 	"github.com/spf13/cobra"
@@ -11,23 +19,44 @@ var (
 	path               string
 	acceptedExtensions = []string{".exe", ".map", ".fr3", ".dll", ".xsd", ".wav", ".jpg"}
 	ignoredFiles       = []string{}
+	server             string
+	parameters         = map[string]string{}
+	progressMode       string
+	readTimeout        time.Duration
+	retries            int
+	verbose            bool
+	quiet              bool
 )
 
 func main() {
 
-	rootCmd := cobra.Command{}
+	rootCmd := cobra.Command{
+		PersistentPreRun: func(cmd *cobra.Command, args []string) {
+			tinygit.SetLogger(newCLILogger(os.Stderr, verbose, quiet))
+		},
+	}
 	rootCmd.CompletionOptions.DisableDefaultCmd = true
-	rootCmd.AddCommand(Init(), Status(), Commit(), Print())
-	rootCmd.Execute()
+	rootCmd.PersistentFlags().BoolVarP(&verbose, "verbose", "v", false, "Mostra mensagens detalhadas")
+	rootCmd.PersistentFlags().BoolVarP(&quiet, "quiet", "q", false, "Mostra apenas erros")
+	rootCmd.AddCommand(Init(), Status(), Add(), Reset(), Commit(), Diff(), Log(), Prune(), Checkout(), Branch(), Tag(), Switch(), Print(), Clone(), Pull(), Push(), Serve(), Keygen(), Sign(), Trust())
+
+	// Ctrl+C cancela a operação em andamento
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	rootCmd.ExecuteContext(ctx)
 }
 
 func Init() *cobra.Command {
+	var history bool
+
 	cmd := &cobra.Command{
 		Use:   "init",
 		Short: "Inicializa o controle de versão",
 		Run: func(cmd *cobra.Command, args []string) {
-			err := tinygit.InitControlVersion(path, acceptedExtensions, ignoredFiles)
-			if err != nil {
+			_, err := tinygit.InitContext(cmd.Context(), path, tinygit.Options{Extensions: acceptedExtensions, Ignore: ignoredFiles, History: history})
+			if errors.Is(err, tinygit.ErrAlreadyInitialized) {
+				fmt.Println("Controle de versão já inicializado.")
+			} else if err != nil {
 				fmt.Println("Erro ao inicializar controle de versão:", err)
 			}
 		},
@@ -36,16 +65,40 @@ func Init() *cobra.Command {
 	cmd.Flags().StringVarP(&path, "directory", "d", "", "Diretório de trabalho")
 	cmd.Flags().StringSliceVarP(&acceptedExtensions, "extensions", "e", acceptedExtensions, "Extensões de arquivos a serem monitoradas")
 	cmd.Flags().StringSliceVarP(&ignoredFiles, "ignore", "i", ignoredFiles, "Arquivos a serem ignorados")
+	cmd.Flags().BoolVar(&history, "history", false, "Guarda o conteúdo de cada commit, necessário para checkout, switch e diff entre commits")
 
 	return cmd
 }
 
 func Status() *cobra.Command {
+	var short, porcelain, asJSON bool
+
 	cmd := &cobra.Command{
 		Use:   "status",
 		Short: "Mostra o status de alterações do diretório monitorado pelo controle de versão",
 		Run: func(cmd *cobra.Command, args []string) {
-			_, _, err := tinygit.StatusControlVersion(path, acceptedExtensions, ignoredFiles)
+			r, err := tinygit.OpenWithOptions(path, tinygit.Options{Extensions: acceptedExtensions, Ignore: ignoredFiles})
+			if err != nil {
+				fmt.Println("Erro ao verificar status:", err)
+				return
+			}
+
+			s, err := r.Status(cmd.Context())
+			if err != nil {
+				fmt.Println("Erro ao verificar status:", err)
+				return
+			}
+
+			switch {
+			case asJSON:
+				err = printStatusJSON(os.Stdout, s)
+			case porcelain:
+				printStatusPorcelain(os.Stdout, s)
+			case short:
+				printStatusShort(os.Stdout, s)
+			default:
+				printStatus(os.Stdout, s)
+			}
 			if err != nil {
 				fmt.Println("Erro ao verificar status:", err)
 			}
@@ -53,20 +106,324 @@ func Status() *cobra.Command {
 	}
 
 	cmd.Flags().StringVarP(&path, "directory", "d", "", "Diretório de trabalho")
+	cmd.Flags().BoolVarP(&short, "short", "s", false, "Mostra um arquivo por linha com a letra da alteração")
+	cmd.Flags().BoolVar(&porcelain, "porcelain", false, "Formato estável para scripts, separado por tabulações")
+	cmd.Flags().BoolVar(&asJSON, "json", false, "Mostra o status em JSON")
+	cmd.MarkFlagsMutuallyExclusive("short", "porcelain", "json")
 	cmd.Flags().StringSliceVarP(&acceptedExtensions, "extensions", "e", acceptedExtensions, "Extensões de arquivos a serem monitoradas")
 	cmd.Flags().StringSliceVarP(&ignoredFiles, "ignore", "i", ignoredFiles, "Arquivos a serem ignorados")
 
 	return cmd
 }
 
+func Add() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "add <caminhos...>",
+		Short: "Prepara os arquivos dos caminhos informados para o próximo commit",
+		Args:  cobra.MinimumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			r, err := tinygit.OpenWithOptions(path, tinygit.Options{Extensions: acceptedExtensions, Ignore: ignoredFiles})
+			if err != nil {
+				fmt.Println("Erro ao preparar arquivos:", err)
+				return
+			}
+
+			err = r.Add(cmd.Context(), args...)
+			if err != nil {
+				fmt.Println("Erro ao preparar arquivos:", err)
+			}
+		},
+	}
+
+	cmd.Flags().StringVarP(&path, "directory", "d", "", "Diretório de trabalho")
+	cmd.Flags().StringSliceVarP(&acceptedExtensions, "extensions", "e", acceptedExtensions, "Extensões de arquivos a serem monitoradas")
+	cmd.Flags().StringSliceVarP(&ignoredFiles, "ignore", "i", ignoredFiles, "Arquivos a serem ignorados")
+
+	return cmd
+}
+
+func Reset() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "reset [caminhos...]",
+		Short: "Retira os arquivos dos caminhos informados, ou todos, do próximo commit",
+		Run: func(cmd *cobra.Command, args []string) {
+			r, err := tinygit.Open(path)
+			if err != nil {
+				fmt.Println("Erro ao retirar arquivos:", err)
+				return
+			}
+
+			err = r.Reset(cmd.Context(), args...)
+			if err != nil {
+				fmt.Println("Erro ao retirar arquivos:", err)
+			}
+		},
+	}
+
+	cmd.Flags().StringVarP(&path, "directory", "d", "", "Diretório de trabalho")
+
+	return cmd
+}
+
 func Commit() *cobra.Command {
+	var message string
+
 	cmd := &cobra.Command{
-		Use:   "commit",
-		Short: "Salva as mudanças no controle de versão",
+		Use:   "commit [caminhos...]",
+		Short: "Salva as mudanças preparadas no controle de versão, ou todas se nenhuma estiver preparada",
 		Run: func(cmd *cobra.Command, args []string) {
-			err := tinygit.CommitControlVersion(path, acceptedExtensions, ignoredFiles)
+			r, err := tinygit.OpenWithOptions(path, tinygit.Options{Extensions: acceptedExtensions, Ignore: ignoredFiles})
 			if err != nil {
 				fmt.Println("Erro ao realizar commit:", err)
+				return
+			}
+
+			c, err := r.Commit(cmd.Context(), message, args...)
+			if errors.Is(err, tinygit.ErrNoChanges) {
+				fmt.Println("Nenhuma mudança detectada.")
+			} else if err != nil {
+				fmt.Println("Erro ao realizar commit:", err)
+			} else {
+				fmt.Println("Commit", c.ID[:8], "salvo.")
+			}
+		},
+	}
+
+	cmd.Flags().StringVarP(&path, "directory", "d", "", "Diretório de trabalho")
+	cmd.Flags().StringVarP(&message, "message", "m", "", "Mensagem do commit")
+	cmd.Flags().StringSliceVarP(&acceptedExtensions, "extensions", "e", acceptedExtensions, "Extensões de arquivos a serem monitoradas")
+	cmd.Flags().StringSliceVarP(&ignoredFiles, "ignore", "i", ignoredFiles, "Arquivos a serem ignorados")
+
+	return cmd
+}
+
+func Diff() *cobra.Command {
+	var stat, nameOnly, asJSON bool
+	var ref string
+
+	cmd := &cobra.Command{
+		Use:   "diff [origem] [destino]",
+		Short: "Compara o diretório de trabalho (worktree), o commit atual (HEAD), um branch, uma tag, um commit ou o servidor (remote)",
+		Long: "Compara dois lados, cada um sendo worktree, HEAD, remote, um branch, uma tag ou o identificador de um commit.\n" +
+			"Sem argumentos compara HEAD com worktree; com um, compara o lado informado com worktree.",
+		Args: cobra.MaximumNArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			from, to := tinygit.DiffHead, tinygit.DiffWorkTree
+			if len(args) > 0 {
+				from = args[0]
+			}
+			if len(args) > 1 {
+				to = args[1]
+			}
+
+			r, err := tinygit.OpenWithOptions(path, tinygit.Options{
+				Extensions: acceptedExtensions,
+				Ignore:     ignoredFiles,
+				Transfer:   tinygit.TransferOptions{Client: tinygit.ClientOptions{ReadTimeout: readTimeout, MaxRetries: retries}},
+			})
+			if err != nil {
+				fmt.Println("Erro ao comparar:", err)
+				return
+			}
+
+			opts := tinygit.DiffOptions{Server: server, Params: withRef(parameters, ref), Text: !stat && !nameOnly}
+			d, err := r.Diff(cmd.Context(), from, to, opts)
+			if err != nil {
+				fmt.Println("Erro ao comparar:", err)
+				return
+			}
+
+			switch {
+			case asJSON:
+				err = printDiffJSON(os.Stdout, d)
+			case stat:
+				printDiffStat(os.Stdout, d)
+			case nameOnly:
+				printDiffNameOnly(os.Stdout, d)
+			default:
+				printDiff(os.Stdout, d)
+			}
+			if err != nil {
+				fmt.Println("Erro ao comparar:", err)
+			}
+		},
+	}
+
+	cmd.Flags().StringVarP(&path, "directory", "d", "", "Diretório de trabalho")
+	cmd.Flags().StringVarP(&server, "server", "s", "", "Endereço do servidor, usado por remote")
+	cmd.Flags().StringToStringVarP(&parameters, "param", "p", parameters, "Parâmetros enviados ao servidor (chave=valor)")
+	cmd.Flags().StringVar(&ref, "ref", "", "Branch ou tag do servidor comparado por remote")
+	cmd.Flags().BoolVar(&stat, "stat", false, "Mostra apenas a diferença de tamanho de cada arquivo")
+	cmd.Flags().BoolVar(&nameOnly, "name-only", false, "Mostra apenas os caminhos dos arquivos")
+	cmd.Flags().BoolVar(&asJSON, "json", false, "Mostra a comparação em JSON, com o diff dos arquivos de texto")
+	cmd.MarkFlagsMutuallyExclusive("stat", "name-only", "json")
+	cmd.Flags().DurationVar(&readTimeout, "timeout", 0, "Tempo máximo sem resposta do servidor (ex.: 30s; padrão 60s)")
+	cmd.Flags().IntVar(&retries, "retries", 0, "Novas tentativas de requisições com falha temporária (padrão 4, -1 desativa)")
+	cmd.Flags().StringSliceVarP(&acceptedExtensions, "extensions", "e", acceptedExtensions, "Extensões de arquivos a serem monitoradas")
+	cmd.Flags().StringSliceVarP(&ignoredFiles, "ignore", "i", ignoredFiles, "Arquivos a serem ignorados")
+
+	return cmd
+}
+
+func Log() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "log",
+		Short: "Lista os commits, do atual ao primeiro",
+		Run: func(cmd *cobra.Command, args []string) {
+			r, err := tinygit.Open(path)
+			if err != nil {
+				fmt.Println("Erro ao listar commits:", err)
+				return
+			}
+
+			commits, err := r.Log()
+			if err != nil {
+				fmt.Println("Erro ao listar commits:", err)
+				return
+			}
+			for _, c := range commits {
+				fmt.Printf("%s %s %s\n", c.ID[:8], c.Time.Format("2006-01-02 15:04:05"), c.Message)
+			}
+		},
+	}
+
+	cmd.Flags().StringVarP(&path, "directory", "d", "", "Diretório de trabalho")
+
+	return cmd
+}
+
+func Prune() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "prune",
+		Short: "Remove o conteúdo guardado que nenhum commit usa",
+		Run: func(cmd *cobra.Command, args []string) {
+			r, err := tinygit.Open(path)
+			if err != nil {
+				fmt.Println("Erro ao remover objetos:", err)
+				return
+			}
+
+			removed, size, err := r.Prune()
+			if err != nil {
+				fmt.Println("Erro ao remover objetos:", err)
+				return
+			}
+			fmt.Printf("%d objetos removidos (%s).\n", removed, formatBytes(size))
+		},
+	}
+
+	cmd.Flags().StringVarP(&path, "directory", "d", "", "Diretório de trabalho")
+
+	return cmd
+}
+
+func Checkout() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "checkout <commit>",
+		Short: "Restaura os arquivos de um commit",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			r, err := tinygit.OpenWithOptions(path, tinygit.Options{Extensions: acceptedExtensions, Ignore: ignoredFiles})
+			if err != nil {
+				fmt.Println("Erro ao restaurar commit:", err)
+				return
+			}
+
+			err = r.Checkout(cmd.Context(), args[0])
+			if err != nil {
+				fmt.Println("Erro ao restaurar commit:", err)
+			}
+		},
+	}
+
+	cmd.Flags().StringVarP(&path, "directory", "d", "", "Diretório de trabalho")
+	cmd.Flags().StringSliceVarP(&acceptedExtensions, "extensions", "e", acceptedExtensions, "Extensões de arquivos a serem monitoradas")
+	cmd.Flags().StringSliceVarP(&ignoredFiles, "ignore", "i", ignoredFiles, "Arquivos a serem ignorados")
+
+	return cmd
+}
+
+func Branch() *cobra.Command {
+	var remove bool
+
+	cmd := &cobra.Command{
+		Use:   "branch [nome] [commit]",
+		Short: "Lista os branches ou cria um branch no commit atual ou no commit, branch ou tag informado",
+		Args:  cobra.MaximumNArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			r, err := tinygit.Open(path)
+			if err != nil {
+				fmt.Println("Erro ao verificar branches:", err)
+				return
+			}
+
+			switch {
+			case remove:
+				if len(args) != 1 {
+					fmt.Println("Informe o branch a remover.")
+					return
+				}
+				err = r.DeleteBranch(args[0])
+			case len(args) > 0:
+				err = r.CreateBranch(args[0], revision(args))
+			default:
+				err = printRefs(r.Branches, r.Branch())
+			}
+			if err != nil {
+				fmt.Println("Erro ao verificar branches:", err)
+			}
+		},
+	}
+
+	cmd.Flags().StringVarP(&path, "directory", "d", "", "Diretório de trabalho")
+	cmd.Flags().BoolVarP(&remove, "delete", "D", false, "Remove o branch, mantendo seus commits")
+
+	return cmd
+}
+
+func Tag() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "tag [nome] [commit]",
+		Short: "Lista as tags ou cria uma tag, que não pode ser movida, no commit atual ou no informado",
+		Args:  cobra.MaximumNArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			r, err := tinygit.Open(path)
+			if err != nil {
+				fmt.Println("Erro ao verificar tags:", err)
+				return
+			}
+
+			if len(args) > 0 {
+				err = r.CreateTag(args[0], revision(args))
+			} else {
+				err = printRefs(r.Tags, "")
+			}
+			if err != nil {
+				fmt.Println("Erro ao verificar tags:", err)
+			}
+		},
+	}
+
+	cmd.Flags().StringVarP(&path, "directory", "d", "", "Diretório de trabalho")
+
+	return cmd
+}
+
+func Switch() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "switch <branch|tag>",
+		Short: "Restaura os arquivos de um branch ou de uma tag",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			r, err := tinygit.OpenWithOptions(path, tinygit.Options{Extensions: acceptedExtensions, Ignore: ignoredFiles})
+			if err != nil {
+				fmt.Println("Erro ao trocar de branch:", err)
+				return
+			}
+
+			err = r.Switch(cmd.Context(), args[0])
+			if err != nil {
+				fmt.Println("Erro ao trocar de branch:", err)
 			}
 		},
 	}
@@ -78,6 +435,30 @@ func Commit() *cobra.Command {
 	return cmd
 }
 
+// Commit, branch ou tag informado após o nome, vazio para o commit atual
+func revision(args []string) string {
+	if len(args) > 1 {
+		return args[1]
+	}
+	return ""
+}
+
+// Lista as refs com o commit, marcando a atual com *
+func printRefs(list func() ([]tinygit.Ref, error), current string) error {
+	refs, err := list()
+	if err != nil {
+		return err
+	}
+	for _, ref := range refs {
+		mark := " "
+		if ref.Name == current {
+			mark = "*"
+		}
+		fmt.Printf("%s %s %s\n", mark, ref.Name, ref.Commit[:8])
+	}
+	return nil
+}
+
 func Print() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "print",
@@ -94,3 +475,293 @@ func Print() *cobra.Command {
 
 	return cmd
 }
+
+func Pull() *cobra.Command {
+	var theirs, ours, backup bool
+	var jobs int
+	var limitRate, ref string
+
+	cmd := &cobra.Command{
+		Use:   "pull",
+		Short: "Atualiza o diretório com as alterações do servidor",
+		Run: func(cmd *cobra.Command, args []string) {
+			rate, err := parseRate(limitRate)
+			if err != nil {
+				fmt.Println("Erro:", err)
+				return
+			}
+
+			reporter, err := newProgressReporter(progressMode)
+			if err != nil {
+				fmt.Println("Erro:", err)
+				return
+			}
+
+			opts := tinygit.PullOptions{Conflict: tinygit.ConflictAbort}
+			opts.Jobs = jobs
+			opts.RateLimit = rate
+			opts.Progress = reporter
+			opts.Client = tinygit.ClientOptions{ReadTimeout: readTimeout, MaxRetries: retries}
+			switch {
+			case theirs:
+				opts.Conflict = tinygit.ConflictTheirs
+			case ours:
+				opts.Conflict = tinygit.ConflictOurs
+			case backup:
+				opts.Conflict = tinygit.ConflictBackup
+			}
+
+			err = tinygit.PullRepositoryContext(cmd.Context(), path, server, withRef(parameters, ref), opts)
+			if errors.Is(err, tinygit.ErrUpToDate) {
+				fmt.Println("Repositório já está atualizado.")
+			} else if err != nil {
+				fmt.Println("Erro ao atualizar repositório:", err)
+			}
+		},
+	}
+
+	cmd.Flags().StringVarP(&path, "directory", "d", "", "Diretório de trabalho")
+	cmd.Flags().StringVarP(&server, "server", "s", "", "Endereço do servidor")
+	cmd.Flags().StringToStringVarP(&parameters, "param", "p", parameters, "Parâmetros enviados ao servidor (chave=valor)")
+	cmd.Flags().StringVar(&ref, "ref", "", "Branch ou tag do servidor (ex.: stable, v2.3.1)")
+	cmd.Flags().Bool("abort", false, "Cancela a atualização se houver conflitos (padrão)")
+	cmd.Flags().BoolVar(&theirs, "theirs", false, "Sobrescreve as alterações locais com a versão do servidor")
+	cmd.Flags().BoolVar(&ours, "ours", false, "Mantém as alterações locais em conflito")
+	cmd.Flags().BoolVar(&backup, "backup", false, "Aplica a versão do servidor mantendo a cópia local como arquivo.orig")
+	cmd.Flags().IntVarP(&jobs, "jobs", "j", 4, "Número de downloads simultâneos")
+	cmd.Flags().StringVar(&limitRate, "limit-rate", "", "Limite de banda em bytes por segundo (ex.: 500k, 2M)")
+	cmd.Flags().StringVar(&progressMode, "progress", "bar", "Exibição do progresso: bar, json ou none")
+	cmd.Flags().DurationVar(&readTimeout, "timeout", 0, "Tempo máximo sem resposta do servidor (ex.: 30s; padrão 60s)")
+	cmd.Flags().IntVar(&retries, "retries", 0, "Novas tentativas de requisições com falha temporária (padrão 4, -1 desativa)")
+	cmd.MarkFlagsMutuallyExclusive("abort", "theirs", "ours", "backup")
+	cmd.MarkFlagRequired("server")
+
+	return cmd
+}
+
+func Push() *cobra.Command {
+	var jobs int
+	var limitRate string
+
+	cmd := &cobra.Command{
+		Use:   "push",
+		Short: "Envia as alterações do diretório para o servidor",
+		Run: func(cmd *cobra.Command, args []string) {
+			rate, err := parseRate(limitRate)
+			if err != nil {
+				fmt.Println("Erro:", err)
+				return
+			}
+
+			reporter, err := newProgressReporter(progressMode)
+			if err != nil {
+				fmt.Println("Erro:", err)
+				return
+			}
+
+			opts := tinygit.PushOptions{}
+			opts.Jobs = jobs
+			opts.RateLimit = rate
+			opts.Progress = reporter
+			opts.Client = tinygit.ClientOptions{ReadTimeout: readTimeout, MaxRetries: retries}
+
+			err = tinygit.PushRepositoryContext(cmd.Context(), path, server, parameters, opts)
+			if errors.Is(err, tinygit.ErrUpToDate) {
+				fmt.Println("Repositório já está atualizado.")
+			} else if err != nil {
+				fmt.Println("Erro ao enviar alterações:", err)
+			}
+		},
+	}
+
+	cmd.Flags().StringVarP(&path, "directory", "d", "", "Diretório de trabalho")
+	cmd.Flags().StringVarP(&server, "server", "s", "", "Endereço do servidor")
+	cmd.Flags().StringToStringVarP(&parameters, "param", "p", parameters, "Parâmetros enviados ao servidor (chave=valor)")
+	cmd.Flags().IntVarP(&jobs, "jobs", "j", 4, "Número de partes enviadas simultaneamente")
+	cmd.Flags().StringVar(&limitRate, "limit-rate", "", "Limite de banda em bytes por segundo (ex.: 500k, 2M)")
+	cmd.Flags().StringVar(&progressMode, "progress", "bar", "Exibição do progresso: bar, json ou none")
+	cmd.Flags().DurationVar(&readTimeout, "timeout", 0, "Tempo máximo sem resposta do servidor (ex.: 30s; padrão 60s)")
+	cmd.Flags().IntVar(&retries, "retries", 0, "Novas tentativas de requisições com falha temporária (padrão 4, -1 desativa)")
+	cmd.MarkFlagRequired("server")
+
+	return cmd
+}
+
+func Clone() *cobra.Command {
+	var limitRate, ref string
+	var history bool
+
+	cmd := &cobra.Command{
+		Use:   "clone",
+		Short: "Clona o repositório do servidor no diretório",
+		Run: func(cmd *cobra.Command, args []string) {
+			rate, err := parseRate(limitRate)
+			if err != nil {
+				fmt.Println("Erro:", err)
+				return
+			}
+
+			reporter, err := newProgressReporter(progressMode)
+			if err != nil {
+				fmt.Println("Erro:", err)
+				return
+			}
+
+			opts := tinygit.CloneOptions{History: history}
+			opts.RateLimit = rate
+			opts.Progress = reporter
+			opts.Client = tinygit.ClientOptions{ReadTimeout: readTimeout, MaxRetries: retries}
+
+			err = tinygit.CloneRepositoryContext(cmd.Context(), path, server, withRef(parameters, ref), opts)
+			if errors.Is(err, tinygit.ErrAlreadyInitialized) {
+				fmt.Println("Controle de versão já inicializado.")
+			} else if err != nil {
+				fmt.Println("Erro ao clonar repositório:", err)
+			}
+		},
+	}
+
+	cmd.Flags().StringVarP(&path, "directory", "d", "", "Diretório de trabalho")
+	cmd.Flags().StringVarP(&server, "server", "s", "", "Endereço do servidor")
+	cmd.Flags().StringToStringVarP(&parameters, "param", "p", parameters, "Parâmetros enviados ao servidor (chave=valor)")
+	cmd.Flags().StringVar(&ref, "ref", "", "Branch ou tag do servidor (ex.: stable, v2.3.1)")
+	cmd.Flags().StringVar(&limitRate, "limit-rate", "", "Limite de banda em bytes por segundo (ex.: 500k, 2M)")
+	cmd.Flags().StringVar(&progressMode, "progress", "bar", "Exibição do progresso: bar, json ou none")
+	cmd.Flags().DurationVar(&readTimeout, "timeout", 0, "Tempo máximo sem resposta do servidor (ex.: 30s; padrão 60s)")
+	cmd.Flags().IntVar(&retries, "retries", 0, "Novas tentativas de requisições com falha temporária (padrão 4, -1 desativa)")
+	cmd.Flags().BoolVar(&history, "history", false, "Guarda o conteúdo de cada commit, necessário para checkout, switch e diff entre commits")
+	cmd.MarkFlagRequired("server")
+
+	return cmd
+}
+
+func Serve() *cobra.Command {
+	var addr, limitRate, connLimitRate string
+
+	cmd := &cobra.Command{
+		Use:   "serve",
+		Short: "Serve o diretório para clone, pull e push",
+		Run: func(cmd *cobra.Command, args []string) {
+			s := tinygit.NewServer(path)
+
+			var err error
+			s.RateLimit, err = parseRate(limitRate)
+			if err != nil {
+				fmt.Println("Erro:", err)
+				return
+			}
+			s.ConnRateLimit, err = parseRate(connLimitRate)
+			if err != nil {
+				fmt.Println("Erro:", err)
+				return
+			}
+
+			fmt.Println("Servindo", path, "em", addr)
+			err = http.ListenAndServe(addr, s)
+			if err != nil {
+				fmt.Println("Erro ao iniciar servidor:", err)
+			}
+		},
+	}
+
+	cmd.Flags().StringVarP(&path, "directory", "d", "", "Diretório de trabalho")
+	cmd.Flags().StringVarP(&addr, "addr", "a", ":8080", "Endereço em que o servidor escuta")
+	cmd.Flags().StringVar(&limitRate, "limit-rate", "", "Limite de banda total de saída em bytes por segundo (ex.: 10M)")
+	cmd.Flags().StringVar(&connLimitRate, "conn-limit-rate", "", "Limite de banda de saída por conexão em bytes por segundo (ex.: 500k)")
+
+	return cmd
+}
+
+// Acrescenta aos parâmetros o branch ou a tag pedido ao servidor
+func withRef(parameters map[string]string, ref string) map[string]string {
+	if ref != "" {
+		parameters[tinygit.RefParam] = ref
+	}
+	return parameters
+}
+
+// Converte um limite como 500k, 2M ou 1G em bytes por segundo. Vazio não limita.
+func parseRate(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, nil
+	}
+
+	multiplier := int64(1)
+	switch strings.ToLower(s[len(s)-1:]) {
+	case "k":
+		multiplier = 1 << 10
+	case "m":
+		multiplier = 1 << 20
+	case "g":
+		multiplier = 1 << 30
+	}
+	if multiplier > 1 {
+		s = s[:len(s)-1]
+	}
+
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("limite de banda inválido: %s", s)
+	}
+	return n * multiplier, nil
+}
+
+func Keygen() *cobra.Command {
+	var output string
+
+	cmd := &cobra.Command{
+		Use:   "keygen",
+		Short: "Gera um par de chaves Ed25519 para assinar versões",
+		Run: func(cmd *cobra.Command, args []string) {
+			err := tinygit.GenerateSigningKey(output)
+			if err != nil {
+				fmt.Println("Erro ao gerar chaves:", err)
+				return
+			}
+			fmt.Println("Chaves geradas em", output, "e", output+".pub")
+		},
+	}
+
+	cmd.Flags().StringVarP(&output, "output", "o", "tinygit.key", "Arquivo da chave privada")
+
+	return cmd
+}
+
+func Sign() *cobra.Command {
+	var key string
+
+	cmd := &cobra.Command{
+		Use:   "sign",
+		Short: "Assina a versão atual do diretório",
+		Run: func(cmd *cobra.Command, args []string) {
+			err := tinygit.SignRelease(path, key)
+			if err != nil {
+				fmt.Println("Erro ao assinar versão:", err)
+			}
+		},
+	}
+
+	cmd.Flags().StringVarP(&path, "directory", "d", "", "Diretório de trabalho")
+	cmd.Flags().StringVarP(&key, "key", "k", "tinygit.key", "Arquivo da chave privada")
+
+	return cmd
+}
+
+func Trust() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "trust <chave.pub>",
+		Short: "Adiciona uma chave pública confiável para verificar atualizações",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			err := tinygit.TrustKey(path, args[0])
+			if err != nil {
+				fmt.Println("Erro ao adicionar chave:", err)
+			}
+		},
+	}
+
+	cmd.Flags().StringVarP(&path, "directory", "d", "", "Diretório de trabalho")
+
+	return cmd
+}
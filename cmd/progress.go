@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/leonardodf95/tinygit"
+)
+
+const progressBarWidth = 30
+
+var phaseNames = map[tinygit.ProgressPhase]string{
+	tinygit.PhaseScan:     "Verificando",
+	tinygit.PhaseCompress: "Compactando",
+	tinygit.PhaseDownload: "Baixando",
+	tinygit.PhaseUpload:   "Enviando",
+	tinygit.PhaseExtract:  "Extraindo",
+}
+
+// Cria o ProgressReporter do modo informado: "bar" desenha uma barra no
+// terminal, "json" escreve um objeto JSON por linha na saída padrão e "none"
+// não informa o progresso
+func newProgressReporter(mode string) (tinygit.ProgressReporter, error) {
+	switch mode {
+	case "bar", "":
+		return &barReporter{out: os.Stderr}, nil
+	case "json":
+		return &jsonReporter{enc: json.NewEncoder(os.Stdout)}, nil
+	case "none":
+		return nil, nil
+	}
+	return nil, fmt.Errorf("modo de progresso inválido: %s", mode)
+}
+
+// Barra de progresso redesenhada na mesma linha do terminal
+type barReporter struct {
+	out   io.Writer
+	phase tinygit.ProgressPhase
+}
+
+func (b *barReporter) Report(p tinygit.Progress) {
+	if p.Phase != b.phase {
+		if b.phase != "" {
+			fmt.Fprintln(b.out)
+		}
+		b.phase = p.Phase
+	}
+
+	line := fmt.Sprintf("%-12s", phaseNames[p.Phase])
+	if p.TotalBytes > 0 {
+		percent := p.Bytes * 100 / p.TotalBytes
+		if percent > 100 {
+			percent = 100
+		}
+		filled := int(percent) * progressBarWidth / 100
+		line += fmt.Sprintf("[%s%s] %3d%% ", strings.Repeat("#", filled), strings.Repeat(".", progressBarWidth-filled), percent)
+		line += formatBytes(p.Bytes) + "/" + formatBytes(p.TotalBytes)
+	} else {
+		line += formatBytes(p.Bytes)
+	}
+
+	if p.TotalFiles > 0 {
+		line += fmt.Sprintf(", %d/%d arquivo(s)", p.Files, p.TotalFiles)
+	} else if p.Files > 0 {
+		line += fmt.Sprintf(", %d arquivo(s)", p.Files)
+	}
+
+	// Volta ao início da linha e apaga o restante da anterior
+	fmt.Fprintf(b.out, "\r%s\x1b[K", line)
+
+	if p.Done {
+		fmt.Fprintln(b.out)
+		b.phase = ""
+	}
+}
+
+// Escreve cada atualização como uma linha JSON, para ser lida por outros programas
+type jsonReporter struct {
+	enc *json.Encoder
+}
+
+func (j *jsonReporter) Report(p tinygit.Progress) {
+	j.enc.Encode(p)
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/leonardodf95/tinygit"
+)
+
+var stateTitles = []struct {
+	state tinygit.FileState
+	title string
+}{
+	{tinygit.FileModified, "Modificados:"},
+	{tinygit.FileAdded, "Adicionados:"},
+	{tinygit.FileRemoved, "Removidos:"},
+}
+
+var stateLetters = map[tinygit.FileState]string{
+	tinygit.FileAdded:    "A",
+	tinygit.FileModified: "M",
+	tinygit.FileRemoved:  "D",
+}
+
+// Lista os arquivos agrupados pela alteração, com o tamanho de cada um. Se
+// houver arquivos preparados, eles são listados separados dos demais.
+func printStatus(w io.Writer, s *tinygit.Status) {
+	if s.Branch != "" {
+		fmt.Fprintln(w, "No branch", s.Branch)
+	}
+	if s.Clean {
+		fmt.Fprintln(w, "Nenhuma mudança detectada.")
+	}
+
+	if len(s.Staged) == 0 {
+		printGroups(w, s.Files, "")
+	} else {
+		fmt.Fprintln(w, "Preparados para o commit:")
+		printGroups(w, s.Staged, "\t")
+		if len(s.Files) > 0 {
+			fmt.Fprintln(w, "Não preparados:")
+			printGroups(w, s.Files, "\t")
+		}
+	}
+
+	if s.Untracked > 0 || s.Ignored > 0 {
+		fmt.Fprintf(w, "%d arquivo(s) não versionado(s), %d ignorado(s)\n", s.Untracked, s.Ignored)
+	}
+}
+
+func printGroups(w io.Writer, files []tinygit.FileStatus, indent string) {
+	for _, group := range stateTitles {
+		printed := false
+		for _, f := range files {
+			if f.State != group.state {
+				continue
+			}
+			if !printed {
+				fmt.Fprintln(w, indent+group.title)
+				printed = true
+			}
+			fmt.Fprintf(w, "%s\t%s (%s)\n", indent, f.Path, formatSizes(f))
+		}
+	}
+}
+
+func formatSizes(f tinygit.FileStatus) string {
+	switch f.State {
+	case tinygit.FileAdded:
+		return formatBytes(f.NewSize)
+	case tinygit.FileRemoved:
+		return formatBytes(f.OldSize)
+	}
+	return formatBytes(f.OldSize) + " -> " + formatBytes(f.NewSize)
+}
+
+// Arquivo com as alterações preparadas e não preparadas
+type statusLine struct {
+	path          string
+	staged, files *tinygit.FileStatus
+}
+
+// Junta as alterações preparadas e não preparadas de cada arquivo, ordenadas
+// pelo caminho
+func statusLines(s *tinygit.Status) []statusLine {
+	lines := []statusLine{}
+	i, j := 0, 0
+	for i < len(s.Staged) || j < len(s.Files) {
+		switch {
+		case j == len(s.Files) || i < len(s.Staged) && s.Staged[i].Path < s.Files[j].Path:
+			lines = append(lines, statusLine{path: s.Staged[i].Path, staged: &s.Staged[i]})
+			i++
+		case i == len(s.Staged) || s.Files[j].Path < s.Staged[i].Path:
+			lines = append(lines, statusLine{path: s.Files[j].Path, files: &s.Files[j]})
+			j++
+		default:
+			lines = append(lines, statusLine{path: s.Files[j].Path, staged: &s.Staged[i], files: &s.Files[j]})
+			i++
+			j++
+		}
+	}
+	return lines
+}
+
+// Letras da alteração preparada e da não preparada, ou none se não houver
+func (l statusLine) letters(none string) string {
+	x, y := none, none
+	if l.staged != nil {
+		x = stateLetters[l.staged.State]
+	}
+	if l.files != nil {
+		y = stateLetters[l.files.State]
+	}
+	return x + y
+}
+
+// Um arquivo por linha, precedido das letras da alteração preparada e da não
+// preparada
+func printStatusShort(w io.Writer, s *tinygit.Status) {
+	for _, l := range statusLines(s) {
+		fmt.Fprintf(w, "%s %s\n", l.letters(" "), l.path)
+	}
+}
+
+// Formato estável para scripts: letras da alteração preparada e da não
+// preparada ("." se não houver), tamanho na árvore salva, tamanho no
+// diretório de trabalho e caminho, separados por tabulações
+func printStatusPorcelain(w io.Writer, s *tinygit.Status) {
+	for _, l := range statusLines(s) {
+		oldSize, newSize := int64(0), int64(0)
+		if l.staged != nil {
+			oldSize, newSize = l.staged.OldSize, l.staged.NewSize
+		}
+		if l.files != nil {
+			newSize = l.files.NewSize
+			if l.staged == nil {
+				oldSize = l.files.OldSize
+			}
+		}
+		fmt.Fprintf(w, "%s\t%d\t%d\t%s\n", l.letters("."), oldSize, newSize, l.path)
+	}
+}
+
+func printStatusJSON(w io.Writer, s *tinygit.Status) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(s)
+}
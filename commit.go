@@ -0,0 +1,360 @@
+package tinygit
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	commitsDirName = "commits"
+	objectsDirName = "objects"
+	// Tamanho mínimo de um prefixo aceito como identificador de commit
+	minCommitPrefix = 4
+	// Idade mínima de um objeto removido por Prune, já que um commit em
+	// andamento guarda o conteúdo antes de gravar o registro que o usa
+	pruneGracePeriod = time.Hour
+)
+
+// Commit representa uma versão salva do repositório
+type Commit struct {
+	ID string `json:"id"`
+	// Commit anterior, vazio no primeiro
+	Parent string `json:"parent,omitempty"`
+	// Hash da árvore salva
+	Head    string    `json:"head"`
+	Message string    `json:"message,omitempty"`
+	Time    time.Time `json:"time"`
+}
+
+// Registro gravado em .tinygit/commits: o commit, sua árvore e os arquivos
+// com o hash do conteúdo, usado para encontrá-los em .tinygit/objects
+type commitRecord struct {
+	Commit
+	Tree  Node            `json:"tree"`
+	Files []ManifestEntry `json:"files"`
+	// Versão do hash dos diretórios de Tree, como em Versioning
+	TreeVersion int `json:"treeVersion,omitempty"`
+}
+
+func newCommitID(c *Commit) string {
+	h := sha1.New()
+	fmt.Fprintf(h, "%s\n%s\n%d\n%s", c.Parent, c.Head, c.Time.UnixNano(), c.Message)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func commitPath(rootPath, id string) string {
+	return filepath.Join(rootPath, versionDirName, commitsDirName, id)
+}
+
+func objectPath(rootPath, contentHash string) string {
+	return filepath.Join(rootPath, versionDirName, objectsDirName, contentHash)
+}
+
+// Grava o commit compactado em .tinygit/commits
+func writeCommit(rootPath string, rec *commitRecord) error {
+	dir := filepath.Join(rootPath, versionDirName, commitsDirName)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("erro ao criar diretório de commits: %v", err)
+	}
+
+	tmp, err := os.CreateTemp(dir, rec.ID+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("erro ao criar o commit: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	rec.TreeVersion = treeHashVersion
+	writer := gzip.NewWriter(tmp)
+	err = json.NewEncoder(writer).Encode(rec)
+	if err == nil {
+		err = writer.Close()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("erro ao gravar o commit: %v", err)
+	}
+
+	return os.Rename(tmp.Name(), commitPath(rootPath, rec.ID))
+}
+
+// Indica se o conteúdo dos arquivos do commit foi guardado
+func (rec *commitRecord) hasContent() bool {
+	return len(rec.Files) > 0 || rec.Tree.Hash == ""
+}
+
+func readCommit(rootPath, id string) (*commitRecord, error) {
+	file, err := os.Open(commitPath(rootPath, id))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%w: commit %s", ErrNotFound, id)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("erro ao abrir o commit: %v", err)
+	}
+	defer file.Close()
+
+	reader, err := gzip.NewReader(file)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao descompactar o commit: %v", err)
+	}
+	defer reader.Close()
+
+	var rec commitRecord
+	err = json.NewDecoder(reader).Decode(&rec)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao decodificar o commit: %v", err)
+	}
+	// O identificador do commit continua o calculado com o Head gravado
+	if upgradeTreeHash(&rec.Tree, rec.TreeVersion) {
+		rec.Head = rec.Tree.Hash
+	}
+	return &rec, nil
+}
+
+// Encontra o commit cujo identificador começa com prefix
+func resolveCommit(rootPath, prefix string) (string, error) {
+	if len(prefix) < minCommitPrefix {
+		return "", fmt.Errorf("identificador de commit muito curto: %s", prefix)
+	}
+
+	entries, err := os.ReadDir(filepath.Join(rootPath, versionDirName, commitsDirName))
+	if err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+
+	var found string
+	for _, entry := range entries {
+		if !strings.HasPrefix(entry.Name(), prefix) || strings.HasSuffix(entry.Name(), ".tmp") {
+			continue
+		}
+		if found != "" {
+			return "", fmt.Errorf("identificador de commit ambíguo: %s", prefix)
+		}
+		found = entry.Name()
+	}
+	if found == "" {
+		return "", fmt.Errorf("%w: commit %s", ErrNotFound, prefix)
+	}
+	return found, nil
+}
+
+// Gera a lista de arquivos da árvore e guarda em .tinygit/objects o conteúdo
+// que ainda não estiver lá. Arquivos com o mesmo caminho e hash em uma das
+// listas conhecidas (do commit anterior ou do índice) reaproveitam sua
+// entrada, sem ler o conteúdo novamente.
+func storeObjects(ctx context.Context, rootPath string, tree *Node, lists ...[]ManifestEntry) ([]ManifestEntry, error) {
+	type key struct{ path, hash string }
+	known := map[key]ManifestEntry{}
+	for _, list := range lists {
+		for _, entry := range list {
+			known[key{entry.Path, entry.Hash}] = entry
+		}
+	}
+
+	files := appendTreeFiles([]ManifestEntry{}, tree)
+
+	for i, entry := range files {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		if prev, found := known[key{entry.Path, entry.Hash}]; found && exists(objectPath(rootPath, prev.ContentHash)) {
+			files[i] = prev
+			continue
+		}
+
+		e, err := newManifestEntry(rootPath, entry.Path)
+		if err != nil {
+			return nil, err
+		}
+		if e.Hash != entry.Hash {
+			return nil, fmt.Errorf("%s foi alterado durante o commit", entry.Path)
+		}
+		err = storeObject(rootPath, e)
+		if err != nil {
+			return nil, fmt.Errorf("erro ao guardar %s: %v", entry.Path, err)
+		}
+		files[i] = *e
+	}
+
+	return files, nil
+}
+
+// Lista os arquivos da árvore, apenas com caminho e hash
+func appendTreeFiles(files []ManifestEntry, node *Node) []ManifestEntry {
+	if node == nil || node.Hash == "" {
+		return files
+	}
+	if node.Type != treeType {
+		return append(files, ManifestEntry{Path: node.Path, Hash: node.Hash, Type: blobType})
+	}
+
+	for _, child := range node.Children {
+		files = appendTreeFiles(files, child)
+	}
+	return files
+}
+
+// Copia o conteúdo do arquivo, compactado, para .tinygit/objects
+func storeObject(rootPath string, entry *ManifestEntry) error {
+	dst := objectPath(rootPath, entry.ContentHash)
+	if exists(dst) {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0700); err != nil {
+		return err
+	}
+
+	src, err := os.Open(filepath.Join(rootPath, entry.Path))
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	tmp, err := os.CreateTemp(filepath.Dir(dst), entry.ContentHash+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	hash := sha1.New()
+	writer := gzip.NewWriter(tmp)
+	_, err = io.Copy(writer, io.TeeReader(src, hash))
+	if err == nil {
+		err = writer.Close()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	if !CompareHashes(hex.EncodeToString(hash.Sum(nil)), entry.ContentHash) {
+		return fmt.Errorf("%s foi alterado durante o commit", entry.Path)
+	}
+	return os.Rename(tmp.Name(), dst)
+}
+
+// Conteúdo descompactado de um objeto de .tinygit/objects
+type objectReader struct {
+	*gzip.Reader
+	file *os.File
+}
+
+func (o *objectReader) Close() error {
+	o.Reader.Close()
+	return o.file.Close()
+}
+
+// Abre o objeto com o conteúdo do arquivo
+func openObject(rootPath string, entry ManifestEntry) (io.ReadCloser, error) {
+	file, err := os.Open(objectPath(rootPath, entry.ContentHash))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%w: objeto de %s", ErrNotFound, entry.Path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	reader, err := gzip.NewReader(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &objectReader{Reader: reader, file: file}, nil
+}
+
+// Extrai um objeto de .tinygit/objects para dst, restaurando a data de
+// modificação salva
+func restoreObject(rootPath string, entry ManifestEntry, dst string) error {
+	reader, err := openObject(rootPath, entry)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	if err := os.MkdirAll(filepath.Dir(dst), os.ModePerm); err != nil {
+		return err
+	}
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, reader)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	return finishStagedFile(dst, entry)
+}
+
+// Prune remove de .tinygit/objects o conteúdo que nenhum commit nem o índice
+// usa, como o de arquivos preparados e depois descartados, retornando o
+// número de objetos removidos e o espaço liberado em bytes
+func (r *Repository) Prune() (int, int64, error) {
+	used := map[string]bool{}
+	if r.index != nil {
+		for _, entry := range r.index.Files {
+			used[entry.ContentHash] = true
+		}
+	}
+
+	entries, err := os.ReadDir(filepath.Join(r.path, versionDirName, commitsDirName))
+	if err != nil && !os.IsNotExist(err) {
+		return 0, 0, fmt.Errorf("erro ao listar os commits: %v", err)
+	}
+	for _, entry := range entries {
+		if strings.HasSuffix(entry.Name(), ".tmp") {
+			continue
+		}
+		// Um commit ilegível impede saber quais objetos ele usa
+		rec, err := readCommit(r.path, entry.Name())
+		if err != nil {
+			return 0, 0, err
+		}
+		for _, file := range rec.Files {
+			used[file.ContentHash] = true
+		}
+	}
+
+	dir := filepath.Join(r.path, versionDirName, objectsDirName)
+	objects, err := os.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return 0, 0, fmt.Errorf("erro ao listar os objetos: %v", err)
+	}
+
+	var removed int
+	var size int64
+	for _, object := range objects {
+		if used[object.Name()] {
+			continue
+		}
+		info, err := object.Info()
+		if err != nil || time.Since(info.ModTime()) < pruneGracePeriod {
+			continue
+		}
+		err = os.Remove(filepath.Join(dir, object.Name()))
+		if err != nil {
+			return removed, size, fmt.Errorf("erro ao remover o objeto %s: %v", object.Name(), err)
+		}
+		removed++
+		size += info.Size()
+	}
+
+	logger().Info("Objetos removidos", "quantidade", removed, "bytes", size)
+	return removed, size, nil
+}
@@ -0,0 +1,261 @@
+package tinygit
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Cria um repositório em um diretório temporário com os arquivos informados
+// (caminho com barras → conteúdo) e salva o primeiro commit
+func newTestRepo(t *testing.T, files map[string]string) *Repository {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		writeTestFile(t, dir, name, content)
+	}
+	r, err := Init(dir, Options{Extensions: []string{".txt"}})
+	if err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+	return r
+}
+
+func writeTestFile(t *testing.T, dir, name, content string) {
+	t.Helper()
+	p := filepath.Join(dir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func removeTestFile(t *testing.T, dir, name string) {
+	t.Helper()
+	if err := os.RemoveAll(filepath.Join(dir, filepath.FromSlash(name))); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func renameTestFile(t *testing.T, dir, from, to string) {
+	t.Helper()
+	err := os.Rename(filepath.Join(dir, filepath.FromSlash(from)), filepath.Join(dir, filepath.FromSlash(to)))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func contentHash(content string) string {
+	h := sha1.Sum([]byte(content))
+	return hex.EncodeToString(h[:])
+}
+
+// Verifica que a árvore tem exatamente os arquivos informados, comparando o
+// hash do conteúdo
+func assertTreeFiles(t *testing.T, tree *Node, want map[string]string) {
+	t.Helper()
+	got := map[string]*Node{}
+	collectBlobs(tree, got)
+	if len(got) != len(want) {
+		t.Errorf("árvore com %d arquivos, esperado %d", len(got), len(want))
+	}
+	for name, content := range want {
+		node, found := got[filepath.FromSlash(name)]
+		if !found {
+			t.Errorf("%s não está na árvore", name)
+			continue
+		}
+		if node.ContentHash != contentHash(content) {
+			t.Errorf("%s: conteúdo salvo diferente de %q", name, content)
+		}
+	}
+}
+
+// Verifica que o Head é o hash da árvore reconstruída do diretório
+func assertHeadMatchesWorkTree(t *testing.T, r *Repository) {
+	t.Helper()
+	tree, err := r.buildTree(context.Background(), nil)
+	if err != nil {
+		t.Fatalf("buildTree: %v", err)
+	}
+	if tree == nil {
+		tree = &Node{}
+	}
+	if r.Head() != tree.Hash {
+		t.Errorf("Head %s diferente do hash da árvore %s", r.Head(), tree.Hash)
+	}
+}
+
+var testFiles = map[string]string{
+	"a.txt":     "a",
+	"dir/b.txt": "b",
+	"dir/c.txt": "c",
+}
+
+func TestCommit(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(t *testing.T, dir string)
+		want   map[string]string
+	}{
+		{
+			name: "apenas adicionados",
+			change: func(t *testing.T, dir string) {
+				writeTestFile(t, dir, "d.txt", "d")
+				writeTestFile(t, dir, "new/e.txt", "e")
+			},
+			want: map[string]string{"a.txt": "a", "dir/b.txt": "b", "dir/c.txt": "c", "d.txt": "d", "new/e.txt": "e"},
+		},
+		{
+			name: "apenas removidos",
+			change: func(t *testing.T, dir string) {
+				removeTestFile(t, dir, "dir/c.txt")
+			},
+			want: map[string]string{"a.txt": "a", "dir/b.txt": "b"},
+		},
+		{
+			name: "diretório removido",
+			change: func(t *testing.T, dir string) {
+				removeTestFile(t, dir, "dir")
+			},
+			want: map[string]string{"a.txt": "a"},
+		},
+		{
+			name: "adicionados, removidos e alterados",
+			change: func(t *testing.T, dir string) {
+				writeTestFile(t, dir, "a.txt", "a2")
+				writeTestFile(t, dir, "dir/d.txt", "d")
+				removeTestFile(t, dir, "dir/b.txt")
+			},
+			want: map[string]string{"a.txt": "a2", "dir/c.txt": "c", "dir/d.txt": "d"},
+		},
+		{
+			name: "renomeado no mesmo diretório",
+			change: func(t *testing.T, dir string) {
+				renameTestFile(t, dir, "dir/c.txt", "dir/d.txt")
+			},
+			want: map[string]string{"a.txt": "a", "dir/b.txt": "b", "dir/d.txt": "c"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newTestRepo(t, testFiles)
+			tt.change(t, r.Path())
+
+			c, err := r.Commit(context.Background(), "teste")
+			if err != nil {
+				t.Fatalf("Commit: %v", err)
+			}
+			if c.Head != r.Head() {
+				t.Errorf("commit com Head %s, repositório com %s", c.Head, r.Head())
+			}
+			assertTreeFiles(t, r.Tree(), tt.want)
+			assertHeadMatchesWorkTree(t, r)
+
+			// O commit foi gravado: ao reabrir, não há alterações
+			r, err = Open(r.Path())
+			if err != nil {
+				t.Fatalf("Open: %v", err)
+			}
+			s, err := r.Status(context.Background())
+			if err != nil {
+				t.Fatalf("Status: %v", err)
+			}
+			if !s.Clean {
+				t.Errorf("status com alterações após o commit: %+v", s.Files)
+			}
+			if _, err := r.Commit(context.Background(), "de novo"); !errors.Is(err, ErrNoChanges) {
+				t.Errorf("segundo Commit: %v, esperado ErrNoChanges", err)
+			}
+		})
+	}
+}
+
+func TestCommitPaths(t *testing.T) {
+	tests := []struct {
+		name  string
+		paths []string
+		want  map[string]string
+		// Arquivos que continuam alterados após o commit
+		pending []string
+	}{
+		{
+			name:    "arquivo",
+			paths:   []string{"a.txt"},
+			want:    map[string]string{"a.txt": "a2", "dir/b.txt": "b", "dir/c.txt": "c"},
+			pending: []string{"dir/b.txt", "dir/c.txt", "dir/d.txt"},
+		},
+		{
+			name:    "diretório",
+			paths:   []string{"dir"},
+			want:    map[string]string{"a.txt": "a", "dir/b.txt": "b2", "dir/d.txt": "d"},
+			pending: []string{"a.txt"},
+		},
+		{
+			name:  "todos os caminhos alterados",
+			paths: []string{"a.txt", "dir"},
+			want:  map[string]string{"a.txt": "a2", "dir/b.txt": "b2", "dir/d.txt": "d"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newTestRepo(t, testFiles)
+			writeTestFile(t, r.Path(), "a.txt", "a2")
+			writeTestFile(t, r.Path(), "dir/b.txt", "b2")
+			writeTestFile(t, r.Path(), "dir/d.txt", "d")
+			removeTestFile(t, r.Path(), "dir/c.txt")
+
+			_, err := r.Commit(context.Background(), "parcial", tt.paths...)
+			if err != nil {
+				t.Fatalf("Commit: %v", err)
+			}
+			assertTreeFiles(t, r.Tree(), tt.want)
+
+			// O Head é o hash da árvore salva, calculado como em buildTree
+			saved := map[string]*Node{}
+			collectBlobs(r.Tree(), saved)
+			if tree := treeFromBlobs(saved); tree.Hash != r.Head() {
+				t.Errorf("Head %s diferente do hash da árvore salva %s", r.Head(), tree.Hash)
+			}
+			if len(tt.pending) == 0 {
+				assertHeadMatchesWorkTree(t, r)
+			}
+
+			s, err := r.Status(context.Background())
+			if err != nil {
+				t.Fatalf("Status: %v", err)
+			}
+			var pending []string
+			for _, f := range s.Files {
+				pending = append(pending, filepath.ToSlash(f.Path))
+			}
+			if len(pending) != len(tt.pending) {
+				t.Fatalf("alterados após o commit: %v, esperado %v", pending, tt.pending)
+			}
+			for i := range pending {
+				if pending[i] != tt.pending[i] {
+					t.Errorf("alterados após o commit: %v, esperado %v", pending, tt.pending)
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestCommitUnknownPath(t *testing.T) {
+	r := newTestRepo(t, testFiles)
+	writeTestFile(t, r.Path(), "a.txt", "a2")
+
+	_, err := r.Commit(context.Background(), "", "missing.txt")
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("Commit: %v, esperado ErrNotFound", err)
+	}
+}
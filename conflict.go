@@ -0,0 +1,178 @@
+package tinygit
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ConflictPolicy define o que fazer com arquivos alterados localmente que
+// também foram alterados no servidor
+type ConflictPolicy int
+
+const (
+	// Cancela o pull sem alterar nenhum arquivo (padrão)
+	ConflictAbort ConflictPolicy = iota
+	// Sobrescreve as alterações locais com a versão do servidor
+	ConflictTheirs
+	// Mantém as alterações locais e ignora a versão do servidor. Não pode
+	// ser usada em diretórios com chaves confiáveis, em que o resultado do
+	// pull deve ser a versão assinada.
+	ConflictOurs
+	// Aplica a versão do servidor mantendo uma cópia local como arquivo.orig
+	ConflictBackup
+)
+
+const origSuffix = ".orig"
+
+// Opções para a operação de pull
+type PullOptions struct {
+	TransferOptions
+	Conflict ConflictPolicy
+}
+
+// ConflictError lista os arquivos em conflito que impediram o pull
+type ConflictError struct {
+	Paths []string
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("conflito em %d arquivo(s) alterado(s) localmente: %s", len(e.Paths), strings.Join(e.Paths, ", "))
+}
+
+// Permite comparar o erro com ErrConflict
+func (e *ConflictError) Is(target error) bool {
+	return target == ErrConflict
+}
+
+// Retorna o caminho de todos os arquivos alterados. Diretórios adicionados ou
+// removidos incluem todos os arquivos abaixo deles e arquivos renomeados
+// incluem os dois caminhos.
+func changedPaths(c *Changes) map[string]bool {
+	paths := map[string]bool{}
+	for _, node := range c.Added {
+		collectBlobPaths(node, paths)
+	}
+	for _, node := range c.Removed {
+		collectBlobPaths(node, paths)
+	}
+	for _, node := range c.Modified {
+		if node.Type == treeType {
+			continue
+		}
+		paths[node.Path] = true
+	}
+	for _, rename := range c.Renamed {
+		paths[rename.OldPath] = true
+		paths[rename.NewPath] = true
+	}
+	return paths
+}
+
+func collectBlobPaths(node *Node, paths map[string]bool) {
+	if node.Type != treeType {
+		paths[node.Path] = true
+		return
+	}
+	for _, child := range node.Children {
+		collectBlobPaths(child, paths)
+	}
+}
+
+// Verifica se path é igual a dir ou está dentro dele
+func isUnder(path, dir string) bool {
+	return path == dir || strings.HasPrefix(path, dir+string(filepath.Separator))
+}
+
+// Compara as alterações locais com os arquivos recebidos na transação e
+// retorna, para cada caminho da transação, os arquivos locais em conflito
+func detectConflicts(local *Changes, tx *pullTransaction) map[string][]string {
+	localPaths := changedPaths(local)
+	conflicts := map[string][]string{}
+
+	for _, rel := range tx.files {
+		if localPaths[rel] {
+			conflicts[rel] = append(conflicts[rel], rel)
+		}
+	}
+
+	for _, rel := range tx.removed {
+		for path := range localPaths {
+			if isUnder(path, rel) {
+				conflicts[rel] = append(conflicts[rel], path)
+			}
+		}
+	}
+
+	return conflicts
+}
+
+// Resolve os conflitos de acordo com a política escolhida, ajustando a
+// transação antes de ela ser aplicada
+func resolveConflicts(tx *pullTransaction, conflicts map[string][]string, policy ConflictPolicy) error {
+	if len(conflicts) == 0 {
+		return nil
+	}
+
+	switch policy {
+	case ConflictTheirs:
+		return nil
+	case ConflictOurs:
+		// Ignorar arquivos da versão assinada salvaria como HEAD uma árvore
+		// diferente da que foi verificada
+		keys, err := loadTrustedKeys(tx.rootPath)
+		if err != nil {
+			return err
+		}
+		if len(keys) > 0 {
+			return fmt.Errorf("a política ours não pode ser usada com versões assinadas: %w", newConflictError(conflicts))
+		}
+		tx.skip(conflicts)
+		return nil
+	case ConflictBackup:
+		for rel := range conflicts {
+			src := filepath.Join(tx.rootPath, rel)
+			err := copyPath(src, src+origSuffix)
+			if err != nil {
+				return fmt.Errorf("erro ao criar cópia de %s: %v", rel, err)
+			}
+		}
+		return nil
+	default:
+		return newConflictError(conflicts)
+	}
+}
+
+// Gera o erro com os arquivos locais em conflito, em ordem
+func newConflictError(conflicts map[string][]string) *ConflictError {
+	var paths []string
+	for _, local := range conflicts {
+		paths = append(paths, local...)
+	}
+	sort.Strings(paths)
+	return &ConflictError{Paths: paths}
+}
+
+// Copia um arquivo ou diretório recursivamente
+func copyPath(src, dst string) error {
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		if info.IsDir() {
+			return os.MkdirAll(target, os.ModePerm)
+		}
+		err = copyFile(path, target)
+		if err != nil {
+			return err
+		}
+		return os.Chtimes(target, info.ModTime(), info.ModTime())
+	})
+}
@@ -0,0 +1,121 @@
+package tinygit
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestResolveConflicts(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy ConflictPolicy
+		// Arquivos esperados depois de aplicar a transação; nil se o pull
+		// for cancelado
+		want map[string]string
+	}{
+		{
+			name:   "abort",
+			policy: ConflictAbort,
+		},
+		{
+			name:   "theirs",
+			policy: ConflictTheirs,
+			want:   map[string]string{"a.txt": "server", "b.txt": "b2", "dir/x.txt": ""},
+		},
+		{
+			name:   "ours",
+			policy: ConflictOurs,
+			want:   map[string]string{"a.txt": "local", "b.txt": "b2", "dir/x.txt": "local x"},
+		},
+		{
+			name:   "backup",
+			policy: ConflictBackup,
+			want: map[string]string{
+				"a.txt": "server", "a.txt" + origSuffix: "local",
+				"b.txt": "b2", "dir/x.txt": "", "dir" + origSuffix + "/x.txt": "local x",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// a.txt e dir/x.txt foram alterados localmente; o servidor
+			// alterou a.txt e b.txt e removeu dir
+			tx := newTestTransaction(t, map[string]string{"a.txt": "local", "b.txt": "b", "dir/x.txt": "local x"})
+			stageTestFile(t, tx, "a.txt", "server")
+			stageTestFile(t, tx, "b.txt", "b2")
+			tx.remove("dir")
+			local := &Changes{Modified: []*Node{
+				{Path: "a.txt", Type: blobType},
+				{Path: filepath.FromSlash("dir/x.txt"), Type: blobType},
+			}}
+
+			conflicts := detectConflicts(local, tx)
+			wantConflicts := map[string][]string{
+				"a.txt": {"a.txt"},
+				"dir":   {filepath.FromSlash("dir/x.txt")},
+			}
+			if !reflect.DeepEqual(conflicts, wantConflicts) {
+				t.Fatalf("conflitos = %v, esperado %v", conflicts, wantConflicts)
+			}
+
+			err := resolveConflicts(tx, conflicts, tt.policy)
+			if tt.want == nil {
+				var conflictErr *ConflictError
+				if !errors.As(err, &conflictErr) || !errors.Is(err, ErrConflict) {
+					t.Fatalf("resolveConflicts: %v, esperado ConflictError", err)
+				}
+				if want := []string{"a.txt", filepath.FromSlash("dir/x.txt")}; !reflect.DeepEqual(conflictErr.Paths, want) {
+					t.Errorf("Paths = %v, esperado %v", conflictErr.Paths, want)
+				}
+				assertFiles(t, tx.rootPath, map[string]string{"a.txt": "local", "b.txt": "b", "dir/x.txt": "local x"})
+				return
+			}
+			if err != nil {
+				t.Fatalf("resolveConflicts: %v", err)
+			}
+
+			if err := tx.apply(); err != nil {
+				t.Fatalf("apply: %v", err)
+			}
+			assertFiles(t, tx.rootPath, tt.want)
+		})
+	}
+}
+
+func TestDetectConflictsWithoutLocalChanges(t *testing.T) {
+	tx := newTestTransaction(t, map[string]string{"a.txt": "a"})
+	stageTestFile(t, tx, "a.txt", "server")
+
+	conflicts := detectConflicts(&Changes{}, tx)
+	if len(conflicts) != 0 {
+		t.Errorf("conflitos = %v, esperado nenhum", conflicts)
+	}
+	if err := resolveConflicts(tx, conflicts, ConflictAbort); err != nil {
+		t.Errorf("resolveConflicts: %v", err)
+	}
+}
+
+func TestResolveConflictsOursWithTrustedKeys(t *testing.T) {
+	tx := newTestTransaction(t, map[string]string{"a.txt": "local"})
+	stageTestFile(t, tx, "a.txt", "server")
+	pub, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, tx.rootPath, versionDirName+"/"+trustedKeysName, base64.StdEncoding.EncodeToString(pub)+"\n")
+
+	conflicts := detectConflicts(&Changes{Modified: []*Node{{Path: "a.txt", Type: blobType}}}, tx)
+	err = resolveConflicts(tx, conflicts, ConflictOurs)
+	var conflictErr *ConflictError
+	if !errors.As(err, &conflictErr) {
+		t.Fatalf("resolveConflicts: %v, esperado ConflictError", err)
+	}
+	if len(tx.files) != 1 {
+		t.Errorf("arquivos preparados = %v, a transação não deveria ser alterada", tx.files)
+	}
+}
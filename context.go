@@ -0,0 +1,28 @@
+package tinygit
+
+import (
+	"context"
+	"io"
+)
+
+// Leitor que falha assim que o contexto é cancelado, permitindo interromper
+// a leitura e o cálculo de hash de arquivos grandes
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (cr *contextReader) Read(p []byte) (int, error) {
+	if err := cr.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return cr.r.Read(p)
+}
+
+// Retorna r sem alterações se o contexto nunca for cancelado
+func readerWithContext(ctx context.Context, r io.Reader) io.Reader {
+	if ctx.Done() == nil {
+		return r
+	}
+	return &contextReader{ctx: ctx, r: r}
+}
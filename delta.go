@@ -0,0 +1,835 @@
+package tinygit
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+)
+
+// Transferências por delta, no estilo do rsync: quem recebe envia a
+// assinatura (checksums por bloco) da sua cópia do arquivo e quem envia
+// responde apenas com os trechos novos e referências aos blocos já existentes.
+
+const (
+	// Arquivos menores que isso são enviados inteiros
+	deltaMinSize    = 64 << 10
+	minBlockSize    = 2 << 10
+	maxBlockSize    = 64 << 10
+	maxLiteralSize  = 64 << 10
+	deltaOpCopy     = 'C'
+	deltaOpData     = 'D'
+	deltaSuffix     = ".tinygit-delta"
+	rollingModulus  = 1 << 16
+	deltaMaxSigSize = 64 << 20
+)
+
+// Assinatura de um arquivo: checksums fraco e forte de cada bloco
+type fileSignature struct {
+	BlockSize int              `json:"blockSize"`
+	Size      int64            `json:"size"`
+	Blocks    []blockSignature `json:"blocks"`
+}
+
+type blockSignature struct {
+	Weak   uint32 `json:"w"`
+	Strong []byte `json:"s"`
+}
+
+// Escolhe o tamanho do bloco proporcional à raiz do tamanho do arquivo
+func deltaBlockSize(size int64) int {
+	bs := int(math.Sqrt(float64(size)))
+	if bs < minBlockSize {
+		return minBlockSize
+	}
+	if bs > maxBlockSize {
+		return maxBlockSize
+	}
+	return bs
+}
+
+// Checksum fraco do rsync, que pode ser atualizado byte a byte
+func weakChecksum(block []byte) (uint32, uint32) {
+	var a, b uint32
+	l := uint32(len(block))
+	for i, c := range block {
+		a += uint32(c)
+		b += (l - uint32(i)) * uint32(c)
+	}
+	return a % rollingModulus, b % rollingModulus
+}
+
+func strongChecksum(block []byte) []byte {
+	sum := sha1.Sum(block)
+	return sum[:]
+}
+
+// Calcula a assinatura do arquivo em filePath
+func calculateSignature(filePath string) (*fileSignature, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	sig := &fileSignature{
+		BlockSize: deltaBlockSize(info.Size()),
+		Size:      info.Size(),
+	}
+
+	block := make([]byte, sig.BlockSize)
+	for {
+		n, err := io.ReadFull(file, block)
+		if n > 0 {
+			a, b := weakChecksum(block[:n])
+			sig.Blocks = append(sig.Blocks, blockSignature{
+				Weak:   a | b<<16,
+				Strong: strongChecksum(block[:n]),
+			})
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return sig, nil
+}
+
+// Escreve as operações do delta, agrupando cópias de blocos consecutivos
+type deltaEncoder struct {
+	w         *bufio.Writer
+	copyStart int
+	copyCount int
+}
+
+func (e *deltaEncoder) copyBlock(index int) error {
+	if e.copyCount > 0 && e.copyStart+e.copyCount == index {
+		e.copyCount++
+		return nil
+	}
+	if err := e.flushCopy(); err != nil {
+		return err
+	}
+	e.copyStart = index
+	e.copyCount = 1
+	return nil
+}
+
+func (e *deltaEncoder) flushCopy() error {
+	if e.copyCount == 0 {
+		return nil
+	}
+	buf := make([]byte, 1, 1+2*binary.MaxVarintLen64)
+	buf[0] = deltaOpCopy
+	buf = binary.AppendUvarint(buf, uint64(e.copyStart))
+	buf = binary.AppendUvarint(buf, uint64(e.copyCount))
+	e.copyCount = 0
+	_, err := e.w.Write(buf)
+	return err
+}
+
+func (e *deltaEncoder) data(p []byte) error {
+	if len(p) == 0 {
+		return nil
+	}
+	if err := e.flushCopy(); err != nil {
+		return err
+	}
+	buf := make([]byte, 1, 1+binary.MaxVarintLen64)
+	buf[0] = deltaOpData
+	buf = binary.AppendUvarint(buf, uint64(len(p)))
+	if _, err := e.w.Write(buf); err != nil {
+		return err
+	}
+	_, err := e.w.Write(p)
+	return err
+}
+
+func (e *deltaEncoder) close() error {
+	if err := e.flushCopy(); err != nil {
+		return err
+	}
+	return e.w.Flush()
+}
+
+// Gera em w o delta que transforma o arquivo descrito por sig no conteúdo de r
+func writeDelta(w io.Writer, sig *fileSignature, r io.Reader) error {
+	bs := sig.BlockSize
+	if bs <= 0 {
+		return errors.New("assinatura inválida")
+	}
+
+	// Índice dos blocos completos pelo checksum fraco
+	index := map[uint32][]int{}
+	for i, block := range sig.Blocks {
+		if int64(i+1)*int64(bs) > sig.Size {
+			break
+		}
+		index[block.Weak] = append(index[block.Weak], i)
+	}
+
+	// O último bloco pode ser menor que os demais
+	lastIndex := -1
+	lastSize := int(sig.Size % int64(bs))
+	if lastSize > 0 {
+		lastIndex = len(sig.Blocks) - 1
+	}
+
+	enc := &deltaEncoder{w: bufio.NewWriter(w)}
+	br := bufio.NewReaderSize(r, 1<<16)
+
+	// O delta começa com o tamanho do bloco usado nas cópias
+	_, err := enc.w.Write(binary.AppendUvarint(nil, uint64(bs)))
+	if err != nil {
+		return err
+	}
+
+	// buf[litStart:start] são dados literais pendentes e buf[start:start+bs]
+	// é a janela comparada com os blocos
+	buf := make([]byte, 0, 2*bs+maxLiteralSize)
+	litStart, start := 0, 0
+	eof := false
+
+	fill := func(n int) error {
+		for !eof && len(buf)-start < n {
+			if len(buf) == cap(buf) {
+				// Descarta o que já foi enviado
+				copy(buf, buf[litStart:])
+				buf = buf[:len(buf)-litStart]
+				start -= litStart
+				litStart = 0
+			}
+			m, err := br.Read(buf[len(buf):cap(buf)])
+			buf = buf[:len(buf)+m]
+			if err == io.EOF {
+				eof = true
+			} else if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	var a, b uint32
+	rolling := false
+	for {
+		if err := fill(bs + 1); err != nil {
+			return err
+		}
+		if len(buf)-start < bs {
+			break
+		}
+
+		window := buf[start : start+bs]
+		if !rolling {
+			a, b = weakChecksum(window)
+			rolling = true
+		}
+
+		matched := -1
+		for _, i := range index[a|b<<16] {
+			if bytes.Equal(sig.Blocks[i].Strong, strongChecksum(window)) {
+				matched = i
+				break
+			}
+		}
+
+		if matched >= 0 {
+			if err := enc.data(buf[litStart:start]); err != nil {
+				return err
+			}
+			if err := enc.copyBlock(matched); err != nil {
+				return err
+			}
+			start += bs
+			litStart = start
+			rolling = false
+			continue
+		}
+
+		if len(buf)-start == bs {
+			// Fim dos dados: a janela não corresponde a nenhum bloco
+			break
+		}
+
+		// Avança a janela um byte
+		out, in := uint32(buf[start]), uint32(buf[start+bs])
+		a = (a + rollingModulus - out + in) % rollingModulus
+		b = (b + rollingModulus*uint32(bs) - uint32(bs)*out + a) % rollingModulus
+		start++
+
+		if start-litStart >= maxLiteralSize {
+			if err := enc.data(buf[litStart:start]); err != nil {
+				return err
+			}
+			litStart = start
+		}
+	}
+
+	// O restante pode coincidir com o último bloco, menor que os demais
+	rest := buf[start:]
+	if lastIndex >= 0 && len(rest) == lastSize && bytes.Equal(sig.Blocks[lastIndex].Strong, strongChecksum(rest)) {
+		if err := enc.data(buf[litStart:start]); err != nil {
+			return err
+		}
+		if err := enc.copyBlock(lastIndex); err != nil {
+			return err
+		}
+	} else if err := enc.data(buf[litStart:]); err != nil {
+		return err
+	}
+
+	return enc.close()
+}
+
+// Reconstrói em w o arquivo a partir da cópia base e do delta
+func applyDelta(base io.ReaderAt, baseSize int64, delta io.Reader, w io.Writer) error {
+	br := bufio.NewReader(delta)
+	blockSize, err := binary.ReadUvarint(br)
+	if err != nil {
+		return err
+	}
+	if blockSize == 0 || blockSize > maxBlockSize {
+		return errors.New("delta inválido: tamanho de bloco")
+	}
+
+	for {
+		op, err := br.ReadByte()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		switch op {
+		case deltaOpCopy:
+			index, err := binary.ReadUvarint(br)
+			if err != nil {
+				return err
+			}
+			count, err := binary.ReadUvarint(br)
+			if err != nil {
+				return err
+			}
+			offset := int64(index) * int64(blockSize)
+			length := int64(count) * int64(blockSize)
+			if offset > baseSize {
+				return errors.New("delta inválido: bloco fora do arquivo")
+			}
+			if offset+length > baseSize {
+				length = baseSize - offset
+			}
+			if _, err := io.Copy(w, io.NewSectionReader(base, offset, length)); err != nil {
+				return err
+			}
+		case deltaOpData:
+			length, err := binary.ReadUvarint(br)
+			if err != nil {
+				return err
+			}
+			if _, err := io.CopyN(w, br, int64(length)); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("delta inválido: operação %q", op)
+		}
+	}
+}
+
+// Reconstrói em dst o arquivo a partir de basePath e do delta
+func applyDeltaFile(basePath string, delta io.Reader, dst string) error {
+	base, err := os.Open(basePath)
+	if err != nil {
+		return err
+	}
+	defer base.Close()
+
+	info, err := base.Stat()
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(dst), os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+
+	err = applyDelta(base, info.Size(), delta, out)
+	if err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// DeltaHandler recebe a assinatura da cópia do cliente e responde com o delta
+// do arquivo cujo hash é o último segmento do caminho (/delta/{hash})
+func DeltaHandler(w http.ResponseWriter, r *http.Request, rootPath string, n Node) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Método não permitido", http.StatusMethodNotAllowed)
+		return
+	}
+
+	hash := path.Base(r.URL.Path)
+	if _, err := hex.DecodeString(hash); err != nil || hash == "" {
+		http.Error(w, "Hash inválido", http.StatusBadRequest)
+		return
+	}
+
+	idx := treeBlobIndex(rootPath, &n)
+	node := idx.find(hash)
+	if node == nil {
+		http.Error(w, "Objeto não encontrado", http.StatusNotFound)
+		return
+	}
+
+	var sig fileSignature
+	err := json.NewDecoder(io.LimitReader(r.Body, deltaMaxSigSize)).Decode(&sig)
+	if err != nil || sig.BlockSize <= 0 {
+		http.Error(w, "Assinatura inválida", http.StatusBadRequest)
+		return
+	}
+
+	file, err := idx.open(rootPath, node)
+	if errors.Is(err, errBlobChanged) {
+		http.Error(w, "Arquivo alterado desde o último commit", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Objeto não encontrado", http.StatusNotFound)
+		return
+	}
+	defer file.Close()
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.WriteHeader(http.StatusOK)
+	err = writeDelta(w, &sig, readerWithContext(r.Context(), file))
+	if err != nil {
+		logger().Error("Erro ao gerar delta", "erro", err)
+	}
+}
+
+// PushSignaturesHandler recebe a lista de caminhos que o cliente pretende
+// enviar e retorna a assinatura dos que já existem na árvore salva do
+// servidor. Arquivos ignorados ou fora do último commit não são expostos.
+func PushSignaturesHandler(w http.ResponseWriter, r *http.Request, rootPath string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Método não permitido", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var paths []string
+	err := json.NewDecoder(io.LimitReader(r.Body, deltaMaxSigSize)).Decode(&paths)
+	if err != nil {
+		http.Error(w, "Requisição inválida", http.StatusBadRequest)
+		return
+	}
+
+	v, err := decompressVersionFile(rootPath)
+	if err != nil {
+		http.Error(w, "Erro ao ler a árvore salva", http.StatusInternalServerError)
+		return
+	}
+	committed := map[string]*Node{}
+	collectBlobs(&v.Tree, committed)
+
+	sigs := map[string]*fileSignature{}
+	for _, relPath := range paths {
+		if r.Context().Err() != nil {
+			return
+		}
+		node, found := committed[filepath.Clean(filepath.FromSlash(relPath))]
+		if !found || node.Size < deltaMinSize {
+			continue
+		}
+		fpath, err := safeJoin(rootPath, node.Path)
+		if err != nil {
+			continue
+		}
+		info, err := os.Stat(fpath)
+		if err != nil || !info.Mode().IsRegular() || info.Size() != node.Size {
+			continue
+		}
+		sig, err := calculateSignature(fpath)
+		if err != nil {
+			continue
+		}
+		sigs[relPath] = sig
+	}
+
+	b, err := json.Marshal(sigs)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(b)
+}
+
+// Tenta reconstruir o arquivo modificado a partir da cópia local e do delta
+// enviado pelo servidor
+func fetchObjectDelta(localPath, fpath string, entry ManifestEntry, serverUrl string, parameters map[string]string, t *transfer) error {
+	sig, err := calculateSignature(localPath)
+	if err != nil {
+		return err
+	}
+
+	b, err := json.Marshal(sig)
+	if err != nil {
+		return err
+	}
+
+	u, err := parseUrlParameter(serverUrl, path.Join("delta", entry.Hash), parameters)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, u, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := t.doSafe(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return newHTTPError("baixar o delta", resp)
+	}
+
+	err = applyDeltaFile(localPath, t.reader(resp.Body), fpath)
+	if err != nil {
+		return err
+	}
+
+	return finishStagedFile(fpath, entry)
+}
+
+// Solicita ao servidor a assinatura dos arquivos que serão enviados no push
+func requestPushSignatures(paths []string, serverUrl string, parameters map[string]string, t *transfer) (map[string]*fileSignature, error) {
+	b, err := json.Marshal(paths)
+	if err != nil {
+		return nil, err
+	}
+
+	u, err := parseUrlParameter(serverUrl, "push/signatures", parameters)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, u, bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := t.doSafe(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, newHTTPError("obter assinaturas", resp)
+	}
+
+	sigs := map[string]*fileSignature{}
+	err = json.NewDecoder(resp.Body).Decode(&sigs)
+	if err != nil {
+		return nil, err
+	}
+	return sigs, nil
+}
+
+// Obtém do servidor a assinatura dos arquivos modificados grandes o bastante
+// para serem enviados como delta. Em caso de erro, todos os arquivos são
+// enviados inteiros.
+func requestDeltaSignatures(c Changes, rootPath string, serverUrl string, parameters map[string]string, t *transfer) map[string]*fileSignature {
+	var paths []string
+	for _, node := range c.Modified {
+		if node.Type == treeType {
+			continue
+		}
+		info, err := os.Stat(filepath.Join(rootPath, node.Path))
+		if err != nil || info.Size() < deltaMinSize {
+			continue
+		}
+		paths = append(paths, node.Path)
+	}
+	if len(paths) == 0 {
+		return nil
+	}
+
+	sigs, err := requestPushSignatures(paths, serverUrl, parameters, t)
+	if err != nil {
+		logger().Warn("Enviando arquivos sem delta", "erro", err)
+		return nil
+	}
+	return sigs
+}
+
+// Adiciona ao zip o delta do arquivo em relação à cópia do servidor
+func addDeltaToZip(ctx context.Context, zipWriter *zip.Writer, path, relPath string, info os.FileInfo, sig *fileSignature) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	header, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return err
+	}
+	header.Name = relPath + deltaSuffix
+	header.Method = zip.Deflate
+
+	writer, err := zipWriter.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+
+	return writeDelta(writer, sig, readerWithContext(ctx, file))
+}
+
+// Uma renomeação do push não pôde ser aplicada. O servidor responde com 409
+// e o cabeçalho de renomeações, e o cliente reenvia o conteúdo dos arquivos.
+var errPushRename = errors.New("renomeação não aplicada")
+
+// Extrai o zip recebido no push. Entradas de delta são reconstruídas a partir
+// da cópia atual e verificadas com o manifesto antes de substituí-la, e os
+// arquivos renomeados são movidos antes da extração, sendo desfeitos se ela
+// falhar.
+func unzipPushFiles(zipPath string, destPath string) (err error) {
+	r, err := zip.OpenReader(zipPath)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	m, err := readManifestFromZip(&r.Reader)
+	if err != nil {
+		return err
+	}
+
+	deltas := map[string]ManifestEntry{}
+	if m != nil {
+		for _, entry := range m.Modified {
+			deltas[entry.Path+deltaSuffix] = entry
+		}
+		var renamed []pushRename
+		renamed, err = applyPushRenames(destPath, m.Renamed)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if err != nil {
+				undoPushRenames(renamed)
+			}
+		}()
+	}
+
+	for _, f := range r.File {
+		if f.Name == manifestEntryName {
+			continue
+		}
+
+		fpath, err := safeJoin(destPath, f.Name)
+		if err != nil {
+			return err
+		}
+
+		if f.FileInfo().IsDir() {
+			os.MkdirAll(fpath, os.ModePerm)
+			continue
+		}
+
+		if entry, found := deltas[f.Name]; found {
+			err = applyZipDelta(f, destPath, entry)
+		} else {
+			err = extractZipFile(f, fpath)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", f.Name, err)
+		}
+	}
+
+	return nil
+}
+
+func applyZipDelta(f *zip.File, destPath string, entry ManifestEntry) error {
+	fpath, err := safeJoin(destPath, entry.Path)
+	if err != nil {
+		return err
+	}
+
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	tempFile, err := os.CreateTemp(filepath.Dir(fpath), ".tinygit-delta-*")
+	if err != nil {
+		return err
+	}
+	tempPath := tempFile.Name()
+	tempFile.Close()
+	defer os.Remove(tempPath)
+
+	err = applyDeltaFile(fpath, rc, tempPath)
+	if err != nil {
+		return err
+	}
+
+	if info, err := os.Stat(fpath); err == nil {
+		os.Chmod(tempPath, info.Mode().Perm())
+	}
+
+	err = finishStagedFile(tempPath, entry)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tempPath, fpath)
+}
+
+// Renomeação aplicada no push, com a data de modificação original para
+// desfazê-la
+type pushRename struct {
+	src, dst string
+	modTime  time.Time
+}
+
+// Aplica as renomeações do manifesto. Se uma falhar, as anteriores são
+// desfeitas e o erro é errPushRename.
+func applyPushRenames(destPath string, entries []ManifestEntry) ([]pushRename, error) {
+	var renamed []pushRename
+	for _, entry := range entries {
+		rename, err := applyPushRename(destPath, entry)
+		if err != nil {
+			undoPushRenames(renamed)
+			return nil, fmt.Errorf("%w: %s: %v", errPushRename, entry.Path, err)
+		}
+		if rename != nil {
+			renamed = append(renamed, *rename)
+		}
+	}
+	return renamed, nil
+}
+
+// Move os arquivos de volta, na ordem inversa, restaurando a data de
+// modificação
+func undoPushRenames(renamed []pushRename) {
+	for i := len(renamed) - 1; i >= 0; i-- {
+		rename := renamed[i]
+		err := os.Rename(rename.dst, rename.src)
+		if err == nil {
+			err = os.Chtimes(rename.src, rename.modTime, rename.modTime)
+		}
+		if err != nil {
+			logger().Error("Erro ao desfazer renomeação", "caminho", rename.src, "erro", err)
+		}
+	}
+}
+
+// Move o arquivo renomeado pelo cliente, desde que a cópia do servidor tenha
+// o conteúdo informado no manifesto. Retorna nil sem erro se o arquivo já
+// estiver no destino, movido por um envio anterior.
+func applyPushRename(destPath string, entry ManifestEntry) (*pushRename, error) {
+	src, err := safeJoin(destPath, entry.From)
+	if err != nil {
+		return nil, err
+	}
+	dst, err := safeJoin(destPath, entry.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := os.Stat(src)
+	if os.IsNotExist(err) {
+		if contentHash, err := calculateContentHash(dst); err == nil && CompareHashes(contentHash, entry.ContentHash) {
+			return nil, nil
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	contentHash, err := calculateContentHash(src)
+	if err != nil {
+		return nil, err
+	}
+	if !CompareHashes(contentHash, entry.ContentHash) {
+		return nil, &IntegrityError{Path: entry.From, Field: "conteúdo", Expected: entry.ContentHash, Actual: contentHash}
+	}
+
+	err = os.MkdirAll(filepath.Dir(dst), os.ModePerm)
+	if err != nil {
+		return nil, err
+	}
+	err = os.Rename(src, dst)
+	if err != nil {
+		return nil, err
+	}
+
+	rename := &pushRename{src: src, dst: dst, modTime: info.ModTime()}
+	if entry.ModTime != 0 {
+		modTime := time.Unix(entry.ModTime, 0)
+		err = os.Chtimes(dst, modTime, modTime)
+		if err != nil {
+			undoPushRenames([]pushRename{*rename})
+			return nil, err
+		}
+	}
+	return rename, nil
+}
+
+// Responde ao erro ao aplicar o push
+func writePushError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errPushRename) {
+		logger().Warn("Renomeação do push não aplicada", "erro", err)
+		w.Header().Set(renamesHeader, "0")
+		http.Error(w, "Renomeação não aplicada, envie o conteúdo dos arquivos", http.StatusConflict)
+		return
+	}
+	logger().Error("Erro ao descompactar arquivos", "erro", err)
+	http.Error(w, "Erro ao descompactar arquivos", http.StatusInternalServerError)
+}
+
+// Indica se o servidor recusou as renomeações do push, caso em que o
+// conteúdo dos arquivos deve ser enviado
+func pushRenameRejected(resp *http.Response) bool {
+	return resp.StatusCode == http.StatusConflict && resp.Header.Get(renamesHeader) != ""
+}
@@ -0,0 +1,99 @@
+package tinygit
+
+import (
+	"bytes"
+	"encoding/json"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Gera conteúdo pseudoaleatório, sem blocos repetidos
+func randomContent(seed int64, size int) []byte {
+	b := make([]byte, size)
+	rand.New(rand.NewSource(seed)).Read(b)
+	return b
+}
+
+func TestDeltaRoundTrip(t *testing.T) {
+	base := randomContent(1, 10*minBlockSize)
+	// O último bloco da assinatura tem metade do tamanho
+	short := randomContent(2, 5*minBlockSize+minBlockSize/2)
+
+	concat := func(parts ...[]byte) []byte {
+		return bytes.Join(parts, nil)
+	}
+
+	tests := []struct {
+		name   string
+		base   []byte
+		target []byte
+		// Tamanho máximo esperado do delta; 0 não verifica
+		maxDelta int
+	}{
+		{name: "arquivos idênticos", base: base, target: base, maxDelta: 64},
+		{name: "inserção no início", base: base, target: concat([]byte("novo"), base), maxDelta: 128},
+		{name: "remoção no meio", base: base, target: concat(base[:3*minBlockSize], base[4*minBlockSize+100:]), maxDelta: 2 * minBlockSize},
+		{name: "bloco final curto", base: short, target: concat(short, []byte("fim"))},
+		{name: "base vazia", base: nil, target: base},
+		{name: "destino vazio", base: base, target: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			basePath := filepath.Join(t.TempDir(), "base")
+			if err := os.WriteFile(basePath, tt.base, 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			sig, err := calculateSignature(basePath)
+			if err != nil {
+				t.Fatalf("calculateSignature: %v", err)
+			}
+
+			var delta bytes.Buffer
+			if err := writeDelta(&delta, sig, bytes.NewReader(tt.target)); err != nil {
+				t.Fatalf("writeDelta: %v", err)
+			}
+			if tt.maxDelta > 0 && delta.Len() > tt.maxDelta {
+				t.Errorf("delta de %d bytes, esperado no máximo %d", delta.Len(), tt.maxDelta)
+			}
+
+			var out bytes.Buffer
+			err = applyDelta(bytes.NewReader(tt.base), int64(len(tt.base)), &delta, &out)
+			if err != nil {
+				t.Fatalf("applyDelta: %v", err)
+			}
+			if !bytes.Equal(out.Bytes(), tt.target) {
+				t.Errorf("resultado com %d bytes diferente do destino com %d bytes", out.Len(), len(tt.target))
+			}
+		})
+	}
+}
+
+func TestPushSignaturesHandlerCommittedOnly(t *testing.T) {
+	content := string(randomContent(3, deltaMinSize))
+	r := newTestRepo(t, map[string]string{"a.txt": content})
+	// Arquivos fora do último commit: um novo e um de extensão não versionada
+	writeTestFile(t, r.path, "b.txt", content)
+	writeTestFile(t, r.path, "c.bin", content)
+
+	body := strings.NewReader(`["a.txt","b.txt","c.bin"]`)
+	rec := httptest.NewRecorder()
+	PushSignaturesHandler(rec, httptest.NewRequest(http.MethodPost, "/push/signatures", body), r.path)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+
+	var sigs map[string]*fileSignature
+	if err := json.Unmarshal(rec.Body.Bytes(), &sigs); err != nil {
+		t.Fatal(err)
+	}
+	if len(sigs) != 1 || sigs["a.txt"] == nil {
+		t.Errorf("%d assinaturas, esperado apenas a de a.txt", len(sigs))
+	}
+}
@@ -0,0 +1,230 @@
+package tinygit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// Lados aceitos por Repository.Diff, além de branches, tags e commits
+const (
+	// Diretório de trabalho
+	DiffWorkTree = "worktree"
+	// Commit atual
+	DiffHead = "HEAD"
+	// Árvore salva no servidor
+	DiffRemote = "remote"
+)
+
+// DiffOptions configura Repository.Diff
+type DiffOptions struct {
+	// Servidor e parâmetros usados quando um dos lados é DiffRemote
+	Server string
+	Params map[string]string
+	// Gera o diff unificado dos arquivos de texto
+	Text bool
+}
+
+// FileDiff descreve um arquivo diferente entre os dois lados. Os campos Old*
+// vêm da origem e os New* do destino.
+type FileDiff struct {
+	FileStatus
+	// Diferença de tamanho, em bytes, do destino em relação à origem
+	SizeDelta int64 `json:"sizeDelta"`
+	// Diff unificado; vazio se DiffOptions.Text não for informado ou se o
+	// arquivo for binário, grande demais ou não tiver o conteúdo disponível
+	Text string `json:"text,omitempty"`
+}
+
+// Diff é o resultado da comparação entre dois lados
+type Diff struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	// Arquivos diferentes, ordenados pelo caminho
+	Files []FileDiff `json:"files"`
+}
+
+// Um lado da comparação: a árvore e a leitura do conteúdo dos arquivos, nil
+// se o conteúdo não estiver disponível
+type diffSide struct {
+	tree *Node
+	open func(path, hash string) (io.ReadCloser, error)
+}
+
+// Diff compara dois lados, cada um sendo DiffWorkTree, DiffHead, DiffRemote,
+// um branch, uma tag ou o identificador (ou prefixo) de um commit. Arquivos
+// com o mesmo conteúdo não são listados, mesmo com outra data de
+// modificação. O percurso do diretório e as requisições ao servidor são
+// interrompidos se ctx for cancelado.
+func (r *Repository) Diff(ctx context.Context, from, to string, opts DiffOptions) (*Diff, error) {
+	var t *transfer
+	if from == DiffRemote || to == DiffRemote {
+		if opts.Server == "" {
+			return nil, fmt.Errorf("servidor não informado")
+		}
+		t = newTransfer(ctx, r.opts.Transfer)
+	}
+
+	fromSide, err := r.diffSide(ctx, from, opts, t)
+	if err != nil {
+		return nil, err
+	}
+	toSide, err := r.diffSide(ctx, to, opts, t)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &Diff{From: from, To: to, Files: []FileDiff{}}
+	for _, f := range compareBlobs(fromSide.tree, toSide.tree, sameContent) {
+		fd := FileDiff{FileStatus: f, SizeDelta: f.NewSize - f.OldSize}
+		if opts.Text {
+			fd.Text, err = diffText(fromSide, toSide, f)
+			if err != nil {
+				return nil, fmt.Errorf("erro ao comparar %s: %w", f.Path, err)
+			}
+		}
+		d.Files = append(d.Files, fd)
+	}
+	return d, nil
+}
+
+// Compara pelo conteúdo quando os dois lados o informam; senão pelo hash,
+// que inclui a data de modificação
+func sameContent(a, b *Node) bool {
+	if a.ContentHash != "" && b.ContentHash != "" {
+		return CompareHashes(a.ContentHash, b.ContentHash)
+	}
+	return CompareHashes(a.Hash, b.Hash)
+}
+
+func (r *Repository) diffSide(ctx context.Context, name string, opts DiffOptions, t *transfer) (*diffSide, error) {
+	switch name {
+	case DiffWorkTree:
+		tree, err := r.buildTree(ctx, nil)
+		if err != nil {
+			return nil, err
+		}
+		return &diffSide{tree: tree, open: func(p, hash string) (io.ReadCloser, error) {
+			return os.Open(filepath.Join(r.path, p))
+		}}, nil
+
+	case DiffRemote:
+		tree, err := requestRemoteTree(opts.Server, opts.Params, t)
+		if err != nil {
+			return nil, fmt.Errorf("erro ao baixar a árvore do servidor: %w", err)
+		}
+		return &diffSide{tree: tree, open: func(p, hash string) (io.ReadCloser, error) {
+			return openRemoteObject(hash, opts.Server, opts.Params, t)
+		}}, nil
+
+	case DiffHead:
+		// Repositórios anteriores ao histórico não têm o conteúdo salvo
+		if r.v.Commit == "" {
+			return &diffSide{tree: &r.v.Tree}, nil
+		}
+		name = r.v.Commit
+	}
+
+	id, err := resolveRevision(r.path, name)
+	if err != nil {
+		return nil, err
+	}
+	rec, err := readCommit(r.path, id)
+	if err != nil {
+		return nil, err
+	}
+	// Sem o conteúdo, apenas os arquivos alterados são listados
+	if !rec.hasContent() {
+		return &diffSide{tree: &rec.Tree}, nil
+	}
+
+	entries := map[string]ManifestEntry{}
+	for _, entry := range rec.Files {
+		entries[entry.Path] = entry
+	}
+	return &diffSide{tree: &rec.Tree, open: func(p, hash string) (io.ReadCloser, error) {
+		entry, found := entries[p]
+		if !found {
+			return nil, fmt.Errorf("%w: objeto de %s", ErrNotFound, p)
+		}
+		return openObject(r.path, entry)
+	}}, nil
+}
+
+// Gera o diff unificado do arquivo, se os dois lados forem texto
+func diffText(from, to *diffSide, f FileStatus) (string, error) {
+	oldContent, ok, err := from.read(f.Path, f.OldHash)
+	if err != nil || !ok {
+		return "", err
+	}
+	newContent, ok, err := to.read(f.Path, f.NewHash)
+	if err != nil || !ok {
+		return "", err
+	}
+	if !isText(oldContent) || !isText(newContent) {
+		return "", nil
+	}
+
+	oldName, newName := "a/"+filepath.ToSlash(f.Path), "b/"+filepath.ToSlash(f.Path)
+	if f.State == FileAdded {
+		oldName = "/dev/null"
+	} else if f.State == FileRemoved {
+		newName = "/dev/null"
+	}
+	text, _ := unifiedDiff(oldName, newName, oldContent, newContent)
+	return text, nil
+}
+
+// Lê o conteúdo do arquivo, vazio se ele não existir no lado. Retorna false
+// se o conteúdo não estiver disponível ou for grande demais para comparar.
+func (s *diffSide) read(p, hash string) ([]byte, bool, error) {
+	if hash == "" {
+		return nil, true, nil
+	}
+	if s.open == nil {
+		return nil, false, nil
+	}
+
+	rc, err := s.open(p, hash)
+	if errors.Is(err, ErrNotFound) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	defer rc.Close()
+
+	content, err := io.ReadAll(io.LimitReader(rc, maxTextDiffSize+1))
+	if err != nil {
+		return nil, false, err
+	}
+	return content, len(content) <= maxTextDiffSize, nil
+}
+
+// Abre o conteúdo de um arquivo do servidor pelo hash
+func openRemoteObject(hash string, serverUrl string, parameters map[string]string, t *transfer) (io.ReadCloser, error) {
+	u, err := parseUrlParameter(serverUrl, path.Join("objects", hash), parameters)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := t.do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, newHTTPError("baixar o objeto", resp)
+	}
+	return resp.Body, nil
+}
@@ -0,0 +1,90 @@
+package tinygit
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// Erros retornados pelas funções públicas, para serem comparados com
+// errors.Is
+var (
+	// O diretório não tem controle de versão
+	ErrNotInitialized = errors.New("controle de versão não inicializado")
+	// O diretório já tem controle de versão
+	ErrAlreadyInitialized = errors.New("controle de versão já inicializado")
+	// O repositório local e o do servidor estão na mesma versão
+	ErrUpToDate = errors.New("repositório já está atualizado")
+	// Não há alterações a salvar
+	ErrNoChanges = errors.New("nenhuma mudança detectada")
+	// O diretório tem alterações que ainda não foram salvas
+	ErrUncommittedChanges = errors.New("há alterações não salvas no diretório")
+	// Arquivos alterados localmente também foram alterados no servidor
+	ErrConflict = errors.New("conflito com alterações locais")
+	// O servidor recusou as credenciais enviadas
+	ErrUnauthorized = errors.New("acesso não autorizado")
+	// O recurso pedido não existe no servidor
+	ErrNotFound = errors.New("não encontrado")
+	// O commit foi salvo sem o conteúdo dos arquivos, com o histórico
+	// desativado
+	ErrNoHistory = errors.New("conteúdo do commit não guardado")
+	// Já existe um branch ou uma tag com o nome informado
+	ErrRefExists = errors.New("branch ou tag já existe")
+	// A atualização não tem assinatura válida de uma chave confiável
+	ErrInvalidSignature = errors.New("assinatura inválida")
+	// Um arquivo recebido não confere com o manifesto
+	ErrCorrupted = errors.New("arquivo corrompido")
+)
+
+// HTTPError é retornado quando o servidor responde com um status inesperado
+type HTTPError struct {
+	// Operação que falhou, como "enviar árvore"
+	Op         string
+	Method     string
+	Path       string
+	StatusCode int
+	Status     string
+}
+
+func newHTTPError(op string, resp *http.Response) *HTTPError {
+	e := &HTTPError{Op: op, StatusCode: resp.StatusCode, Status: resp.Status}
+	if resp.Request != nil {
+		e.Method = resp.Request.Method
+		e.Path = resp.Request.URL.Path
+	}
+	return e
+}
+
+func (e *HTTPError) Error() string {
+	return fmt.Sprintf("erro ao %s, status: %s", e.Op, e.Status)
+}
+
+// Permite comparar o erro com ErrUnauthorized, ErrNotFound e ErrConflict
+func (e *HTTPError) Is(target error) bool {
+	switch target {
+	case ErrUnauthorized:
+		return e.StatusCode == http.StatusUnauthorized || e.StatusCode == http.StatusForbidden
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound
+	case ErrConflict:
+		return e.StatusCode == http.StatusConflict
+	}
+	return false
+}
+
+// IntegrityError indica um arquivo recebido que não confere com o manifesto
+type IntegrityError struct {
+	Path string
+	// Campo verificado: "tamanho" ou "conteúdo"
+	Field    string
+	Expected string
+	Actual   string
+}
+
+func (e *IntegrityError) Error() string {
+	return fmt.Sprintf("%s: %s divergente (esperado %s, recebido %s)", e.Path, e.Field, e.Expected, e.Actual)
+}
+
+func (e *IntegrityError) Is(target error) bool {
+	return target == ErrCorrupted
+}
@@ -4,11 +4,13 @@ import (
 	"archive/zip"
 	"bytes"
 	"compress/gzip"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 )
 
@@ -17,22 +19,23 @@ func generateVersionFile(rootPath string, v *Versioning) error {
 	if _, err := os.Stat(dirVerision); os.IsNotExist(err) {
 		err := generateVersionDir(rootPath)
 		if err != nil {
-			fmt.Println("Erro ao criar diretório de versão:", err)
+			logger().Error("Erro ao criar diretório de versão", "erro", err)
 			return err
 		}
 	}
 
+	v.TreeVersion = treeHashVersion
 	versionJson, err := json.MarshalIndent(v, "", "  ")
 
 	if err != nil {
-		fmt.Println("Erro ao codificar a árvore em JSON:", err)
+		logger().Error("Erro ao codificar a árvore em JSON", "erro", err)
 		return err
 	}
 
 	err = compressVersionFile(dirVerision, versionJson)
 
 	if err != nil {
-		fmt.Println("Erro ao compactar o arquivo de versão:", err)
+		logger().Error("Erro ao compactar o arquivo de versão", "erro", err)
 		return err
 	}
 
@@ -92,6 +95,9 @@ func decompressVersionFile(rootPath string) (*Versioning, error) {
 	if err != nil {
 		return nil, fmt.Errorf("erro ao decodificar o JSON: %v", err)
 	}
+	if upgradeTreeHash(&v.Tree, v.TreeVersion) {
+		v.Head = v.Tree.Hash
+	}
 
 	return &v, nil
 }
@@ -106,7 +112,7 @@ func contains(slice []string, element string) bool {
 }
 
 // Lê um diretório e retorna os nós filhos
-func readDir(rootPath, dirPath string, ext, ignore *[]string) ([]*Node, error) {
+func (b *treeBuilder) readDir(dirPath string) ([]*Node, error) {
 	dirEntries, err := os.ReadDir(dirPath)
 	if err != nil {
 		return nil, err
@@ -114,11 +120,19 @@ func readDir(rootPath, dirPath string, ext, ignore *[]string) ([]*Node, error) {
 
 	var children []*Node
 	for _, entry := range dirEntries {
-		if !entry.IsDir() && !contains(*ext, filepath.Ext(entry.Name())) || entry.Name() == versionDirName || contains(*ignore, entry.Name()) {
+		if entry.Name() == versionDirName {
+			continue
+		}
+		if contains(*b.ignore, entry.Name()) {
+			b.ignored++
+			continue
+		}
+		if !entry.IsDir() && !contains(*b.ext, filepath.Ext(entry.Name())) {
+			b.untracked++
 			continue
 		}
 		childPath := filepath.Join(dirPath, entry.Name())
-		childNode, err := buildTree(rootPath, childPath, ext, ignore)
+		childNode, err := b.build(childPath)
 		if err != nil {
 			return nil, err
 		}
@@ -147,57 +161,146 @@ func VerifyIfExistVersionControl(path string) bool {
 }
 
 func CompressFilesToSend(c Changes, rootPath string) ([]bytes.Buffer, error) {
-	var files []string
+	var buf bytes.Buffer
+	err := writeFilesZip(context.Background(), &buf, c, rootPath, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return []bytes.Buffer{buf}, nil
+}
+
+// Escreve em w um zip com os arquivos adicionados e modificados. Os arquivos
+// são lidos um a um do diretório de trabalho, permitindo enviar o zip sem
+// mantê-lo em memória, e antes disso comparados com os nós de c: se algum
+// tiver sido alterado depois do commit, nada é enviado e o erro é
+// errBlobChanged. Arquivos com assinatura em sigs são enviados como delta da
+// cópia do servidor, descritos em um manifesto para que o resultado seja
+// verificado. Arquivos renomeados constam apenas no manifesto e são movidos
+// pelo servidor. O andamento é informado em p e a compactação é
+// interrompida se ctx for cancelado.
+func writeFilesZip(ctx context.Context, w io.Writer, c Changes, rootPath string, sigs map[string]*fileSignature, p *progress) error {
+	nodes := map[string]*Node{}
 	for _, node := range c.Added {
-		if node.Type == treeType {
-			continue
-		}
-		files = append(files, node.Path)
+		collectBlobs(node, nodes)
 	}
 	for _, node := range c.Modified {
 		if node.Type == treeType {
 			continue
 		}
-		files = append(files, node.Path)
+		nodes[node.Path] = node
 	}
 
-	var buf bytes.Buffer
-	zipWriter := zip.NewWriter(&buf)
-	for _, file := range files {
-		fileInfo, err := os.Stat(file)
+	files := make([]string, 0, len(nodes))
+	for path := range nodes {
+		files = append(files, path)
+	}
+	sort.Strings(files)
+
+	for _, relPath := range files {
+		err := verifyCommittedFile(rootPath, nodes[relPath])
 		if err != nil {
-			return nil, err
+			return err
 		}
-		file, err := os.Open(file)
+	}
+	for _, rename := range c.Renamed {
+		err := verifyCommittedFile(rootPath, rename.Node)
 		if err != nil {
-			return nil, err
+			return err
+		}
+	}
+
+	zipWriter := zip.NewWriter(w)
+
+	m := &Manifest{}
+	for _, relPath := range files {
+		if sigs[relPath] == nil {
+			continue
 		}
-		defer file.Close()
-		header, err := zip.FileInfoHeader(fileInfo)
+		entry, err := treeManifestEntry(rootPath, nodes[relPath])
 		if err != nil {
-			return nil, err
+			return err
 		}
+		m.Modified = append(m.Modified, *entry)
+	}
+	for _, rename := range c.Renamed {
+		entry, err := treeManifestEntry(rootPath, rename.Node)
+		if err != nil {
+			return err
+		}
+		entry.From = rename.OldPath
+		m.Renamed = append(m.Renamed, *entry)
+	}
+	if len(m.Modified) > 0 || len(m.Renamed) > 0 {
+		err := writeManifestToZip(zipWriter, m)
+		if err != nil {
+			return err
+		}
+	}
 
-		header.Name = filepath.Base(file.Name())
-		header.Method = zip.Deflate
-		writer, err := zipWriter.CreateHeader(header)
+	infos := make([]os.FileInfo, len(files))
+	var totalBytes int64
+	for i, relPath := range files {
+		info, err := os.Stat(filepath.Join(rootPath, relPath))
 		if err != nil {
-			return nil, err
+			return err
+		}
+		infos[i] = info
+		totalBytes += info.Size()
+	}
+
+	p.start(PhaseCompress, totalBytes, len(files))
+	for i, relPath := range files {
+		path := filepath.Join(rootPath, relPath)
+		info := infos[i]
+
+		var err error
+		if sig := sigs[relPath]; sig != nil {
+			err = addDeltaToZip(ctx, zipWriter, path, relPath, info, sig)
+		} else {
+			err = addFileToZip(ctx, zipWriter, path, relPath, info)
 		}
-		_, err = io.Copy(writer, file)
 		if err != nil {
-			return nil, err
+			return err
 		}
+		p.add(info.Size(), 1)
 	}
+
 	err := zipWriter.Close()
 	if err != nil {
-		return nil, err
+		return err
 	}
+	p.finish()
+	return nil
+}
 
-	return []bytes.Buffer{buf}, nil
+// Verifica se o arquivo em disco ainda é o salvo no commit, para que o push
+// não envie alterações que não foram salvas. Árvores antigas, sem o hash do
+// conteúdo, só têm o tamanho verificado.
+func verifyCommittedFile(rootPath string, node *Node) error {
+	path := filepath.Join(rootPath, node.Path)
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if info.Size() != node.Size {
+		return fmt.Errorf("%s: %w", node.Path, errBlobChanged)
+	}
+	if node.ContentHash == "" {
+		return nil
+	}
+
+	contentHash, err := calculateContentHash(path)
+	if err != nil {
+		return err
+	}
+	if !CompareHashes(contentHash, node.ContentHash) {
+		return fmt.Errorf("%s: %w", node.Path, errBlobChanged)
+	}
+	return nil
 }
 
-func addFileToZip(zipWriter *zip.Writer, path, relPath string, info os.FileInfo) error {
+func addFileToZip(ctx context.Context, zipWriter *zip.Writer, path, relPath string, info os.FileInfo) error {
 	file, err := os.Open(path)
 	if err != nil {
 		return err
@@ -216,6 +319,6 @@ func addFileToZip(zipWriter *zip.Writer, path, relPath string, info os.FileInfo)
 		return err
 	}
 
-	_, err = io.Copy(writer, file)
+	_, err = io.Copy(writer, readerWithContext(ctx, file))
 	return err
 }
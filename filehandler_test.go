@@ -0,0 +1,24 @@
+package tinygit
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestWriteFilesZipRejectsUncommittedContent(t *testing.T) {
+	r := newTestRepo(t, testFiles)
+	c := Changes{Added: []*Node{r.Tree()}}
+
+	if err := writeFilesZip(context.Background(), io.Discard, c, r.path, nil, nil); err != nil {
+		t.Fatalf("writeFilesZip: %v", err)
+	}
+
+	// Mesmo tamanho, conteúdo diferente do salvo
+	writeTestFile(t, r.path, "dir/b.txt", "x")
+	err := writeFilesZip(context.Background(), io.Discard, c, r.path, nil, nil)
+	if !errors.Is(err, errBlobChanged) {
+		t.Errorf("writeFilesZip: %v, esperado errBlobChanged", err)
+	}
+}
@@ -0,0 +1,172 @@
+package tinygit
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+const indexFileName = "index"
+
+// Índice gravado em .tinygit/index: a árvore preparada para o próximo commit
+// e seus arquivos, cujo conteúdo já está em .tinygit/objects. O arquivo só
+// existe enquanto a árvore preparada for diferente da árvore salva.
+type indexRecord struct {
+	Tree  Node            `json:"tree"`
+	Files []ManifestEntry `json:"files"`
+	// Versão do hash dos diretórios de Tree, como em Versioning
+	TreeVersion int `json:"treeVersion,omitempty"`
+}
+
+func indexPath(rootPath string) string {
+	return filepath.Join(rootPath, versionDirName, indexFileName)
+}
+
+// Lê o índice, retornando nil se não houver arquivos preparados
+func readIndex(rootPath string) (*indexRecord, error) {
+	file, err := os.Open(indexPath(rootPath))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("erro ao abrir o índice: %v", err)
+	}
+	defer file.Close()
+
+	reader, err := gzip.NewReader(file)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao descompactar o índice: %v", err)
+	}
+	defer reader.Close()
+
+	var idx indexRecord
+	err = json.NewDecoder(reader).Decode(&idx)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao decodificar o índice: %v", err)
+	}
+	upgradeTreeHash(&idx.Tree, idx.TreeVersion)
+	return &idx, nil
+}
+
+func writeIndex(rootPath string, idx *indexRecord) error {
+	dir := filepath.Join(rootPath, versionDirName)
+	tmp, err := os.CreateTemp(dir, indexFileName+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("erro ao criar o índice: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	idx.TreeVersion = treeHashVersion
+	writer := gzip.NewWriter(tmp)
+	err = json.NewEncoder(writer).Encode(idx)
+	if err == nil {
+		err = writer.Close()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("erro ao gravar o índice: %v", err)
+	}
+
+	return os.Rename(tmp.Name(), indexPath(rootPath))
+}
+
+func removeIndex(rootPath string) error {
+	err := os.Remove(indexPath(rootPath))
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("erro ao remover o índice: %v", err)
+	}
+	return nil
+}
+
+// Retorna a árvore preparada para o commit, ou a árvore salva se não houver
+// arquivos preparados
+func (r *Repository) stagedTree() *Node {
+	if r.index != nil {
+		return &r.index.Tree
+	}
+	return &r.v.Tree
+}
+
+// Add prepara para o próximo commit os arquivos nos caminhos informados (ou
+// abaixo deles, no caso de diretórios), como estão no diretório de trabalho.
+// Arquivos removidos do diretório de trabalho são preparados como removidos.
+// Com o histórico ativado, o conteúdo é copiado para .tinygit/objects. O
+// push, porém, sempre lê os arquivos do diretório de trabalho e falha se
+// algum tiver sido alterado depois de preparado e salvo.
+func (r *Repository) Add(ctx context.Context, paths ...string) error {
+	if len(paths) == 0 {
+		return fmt.Errorf("nenhum caminho informado")
+	}
+	selected, err := r.relativePaths(paths)
+	if err != nil {
+		return err
+	}
+
+	current, err := r.buildTree(ctx, nil)
+	if err != nil {
+		logger().Error("Erro ao construir a árvore", "erro", err)
+		return err
+	}
+
+	tree, err := selectTree(r.stagedTree(), current, selected)
+	if err != nil {
+		return err
+	}
+	return r.stage(ctx, tree)
+}
+
+// Reset retira do índice os arquivos nos caminhos informados, que voltam a
+// ser como na árvore salva. Sem caminhos, descarta todo o índice. O
+// diretório de trabalho não é alterado.
+func (r *Repository) Reset(ctx context.Context, paths ...string) error {
+	if r.index == nil {
+		return nil
+	}
+	if len(paths) == 0 {
+		return r.stage(ctx, &r.v.Tree)
+	}
+	selected, err := r.relativePaths(paths)
+	if err != nil {
+		return err
+	}
+
+	tree, err := selectTree(r.stagedTree(), &r.v.Tree, selected)
+	if err != nil {
+		return err
+	}
+	return r.stage(ctx, tree)
+}
+
+// Grava a árvore como o novo índice, removendo-o se ela for igual à árvore
+// salva
+func (r *Repository) stage(ctx context.Context, tree *Node) error {
+	if tree == nil {
+		tree = &Node{Hash: ""}
+	}
+	if CompareHashes(tree.Hash, r.v.Head) {
+		err := removeIndex(r.path)
+		if err != nil {
+			return err
+		}
+		r.index = nil
+		return nil
+	}
+
+	files, err := r.storeTree(ctx, tree)
+	if err != nil {
+		return err
+	}
+
+	idx := &indexRecord{Tree: *tree, Files: files}
+	err = writeIndex(r.path, idx)
+	if err != nil {
+		return err
+	}
+	r.index = idx
+	return nil
+}
@@ -0,0 +1,64 @@
+package tinygit
+
+import "sync/atomic"
+
+// Logger recebe as mensagens da biblioteca. Os argumentos são pares chave e
+// valor, como em log/slog; um *slog.Logger satisfaz esta interface.
+type Logger interface {
+	Debug(msg string, args ...any)
+	Info(msg string, args ...any)
+	Warn(msg string, args ...any)
+	Error(msg string, args ...any)
+}
+
+// Logger que descarta todas as mensagens, usado por padrão
+type nopLogger struct{}
+
+func (nopLogger) Debug(msg string, args ...any) {}
+func (nopLogger) Info(msg string, args ...any)  {}
+func (nopLogger) Warn(msg string, args ...any)  {}
+func (nopLogger) Error(msg string, args ...any) {}
+
+type loggerBox struct {
+	Logger
+}
+
+var currentLogger atomic.Pointer[loggerBox]
+
+// SetLogger define o Logger usado pela biblioteca e pelos handlers do
+// servidor. nil volta a descartar as mensagens.
+func SetLogger(l Logger) {
+	if l == nil {
+		l = nopLogger{}
+	}
+	currentLogger.Store(&loggerBox{l})
+}
+
+func logger() Logger {
+	if box := currentLogger.Load(); box != nil {
+		return box.Logger
+	}
+	return nopLogger{}
+}
+
+// Registra os arquivos alterados, sem os diretórios
+func logChanges(c *Changes) {
+	for _, node := range c.Modified {
+		if node.Type != treeType {
+			logger().Debug("Modificado", "caminho", node.Path)
+		}
+	}
+	for _, node := range c.Added {
+		if node.Type != treeType {
+			logger().Debug("Adicionado", "caminho", node.Path)
+		}
+	}
+	for _, node := range c.Removed {
+		if node.Type != treeType {
+			logger().Debug("Removido", "caminho", node.Path)
+		}
+	}
+	for _, rename := range c.Renamed {
+		logger().Debug("Renomeado", "de", rename.OldPath, "para", rename.NewPath, "similaridade", rename.Similarity)
+	}
+}
@@ -0,0 +1,238 @@
+package tinygit
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// Nome da entrada do zip que contém o manifesto das alterações
+const manifestEntryName = ".tinygit-manifest.json"
+
+// Manifest descreve as alterações enviadas pelo servidor junto com os arquivos
+type Manifest struct {
+	// Hash da árvore do servidor, conferido com a versão assinada
+	Head     string          `json:"head,omitempty"`
+	Added    []ManifestEntry `json:"added"`
+	Modified []ManifestEntry `json:"modified"`
+	Removed  []ManifestEntry `json:"removed"`
+	// Arquivos renomeados, copiados do caminho em From no destino em vez de
+	// transferidos
+	Renamed []ManifestEntry `json:"renamed,omitempty"`
+}
+
+// ManifestEntry representa um arquivo (ou diretório removido) do manifesto
+type ManifestEntry struct {
+	Path        string `json:"path"`
+	Hash        string `json:"hash"`
+	ContentHash string `json:"contentHash,omitempty"`
+	Type        string `json:"type"`
+	Size        int64  `json:"size"`
+	ModTime     int64  `json:"modTime,omitempty"`
+	// Caminho anterior dos arquivos renomeados
+	From string `json:"from,omitempty"`
+}
+
+// Gera o manifesto a partir das alterações entre as árvores, com os dados da
+// árvore salva dos arquivos adicionados e modificados. Diretórios
+// adicionados são expandidos em seus arquivos. head é o hash da árvore do
+// servidor.
+func newManifest(rootPath, head string, c *Changes) (*Manifest, error) {
+	m := &Manifest{
+		Head:     head,
+		Added:    []ManifestEntry{},
+		Modified: []ManifestEntry{},
+		Removed:  []ManifestEntry{},
+	}
+
+	var err error
+	for _, node := range c.Added {
+		m.Added, err = appendBlobEntries(m.Added, rootPath, node)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, node := range c.Modified {
+		if node.Type == treeType {
+			continue
+		}
+		m.Modified, err = appendBlobEntries(m.Modified, rootPath, node)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, node := range c.Removed {
+		m.Removed = append(m.Removed, ManifestEntry{Path: node.Path, Hash: node.Hash, Type: node.Type})
+	}
+	for _, rename := range c.Renamed {
+		entry, err := treeManifestEntry(rootPath, rename.Node)
+		if err != nil {
+			return nil, err
+		}
+		entry.From = rename.OldPath
+		m.Renamed = append(m.Renamed, *entry)
+	}
+
+	m.sort()
+	return m, nil
+}
+
+// Gera o manifesto de um clone com os arquivos da árvore salva. Arquivos que
+// não fazem parte do último commit, como os ignorados, não são enviados.
+func cloneManifest(rootPath string, v *Versioning) (*Manifest, error) {
+	m := &Manifest{Head: v.Head, Added: []ManifestEntry{}, Modified: []ManifestEntry{}, Removed: []ManifestEntry{}}
+	if v.Tree.Hash != "" {
+		var err error
+		m.Added, err = appendBlobEntries(m.Added, rootPath, &v.Tree)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	m.sort()
+	return m, nil
+}
+
+// Ordena as entradas por caminho, tornando o manifesto determinístico
+func (m *Manifest) sort() {
+	for _, entries := range [][]ManifestEntry{m.Added, m.Modified, m.Removed, m.Renamed} {
+		sort.Slice(entries, func(i, j int) bool { return entries[i].Path < entries[j].Path })
+	}
+}
+
+func appendBlobEntries(entries []ManifestEntry, rootPath string, node *Node) ([]ManifestEntry, error) {
+	if node.Type == treeType {
+		var err error
+		for _, child := range node.Children {
+			entries, err = appendBlobEntries(entries, rootPath, child)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return entries, nil
+	}
+
+	entry, err := treeManifestEntry(rootPath, node)
+	if err != nil {
+		return nil, err
+	}
+	return append(entries, *entry), nil
+}
+
+// Gera a entrada do manifesto de um arquivo da árvore salva. Os hashes e o
+// tamanho vêm da árvore e do disco é lida apenas a data de modificação, sem
+// ler o conteúdo; ObjectHandler confere o arquivo ao enviá-lo. Árvores
+// antigas, sem o hash do conteúdo, têm o arquivo lido.
+func treeManifestEntry(rootPath string, node *Node) (*ManifestEntry, error) {
+	if node.ContentHash == "" {
+		entry, err := newManifestEntry(rootPath, node.Path)
+		if err != nil {
+			return nil, err
+		}
+		// O hash da árvore identifica o objeto em ObjectHandler
+		entry.Hash = node.Hash
+		return entry, nil
+	}
+
+	info, err := os.Stat(filepath.Join(rootPath, node.Path))
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() != node.Size {
+		logger().Warn("Arquivo alterado desde o último commit", "caminho", node.Path)
+	}
+
+	return &ManifestEntry{
+		Path:        node.Path,
+		Hash:        node.Hash,
+		ContentHash: node.ContentHash,
+		Type:        blobType,
+		Size:        node.Size,
+		ModTime:     info.ModTime().Unix(),
+	}, nil
+}
+
+// Gera a entrada do manifesto de um arquivo a partir do disco
+func newManifestEntry(rootPath, relPath string) (*ManifestEntry, error) {
+	path := filepath.Join(rootPath, relPath)
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	hash, contentHash, err := calculateFileHashes(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ManifestEntry{
+		Path:        relPath,
+		Hash:        hash,
+		ContentHash: contentHash,
+		Type:        blobType,
+		Size:        info.Size(),
+		ModTime:     info.ModTime().Unix(),
+	}, nil
+}
+
+// Retorna os arquivos que devem ser transferidos (adicionados e modificados)
+func (m *Manifest) files() []ManifestEntry {
+	files := make([]ManifestEntry, 0, len(m.Added)+len(m.Modified))
+	files = append(files, m.Added...)
+	return append(files, m.Modified...)
+}
+
+// Retorna os caminhos removidos, inclusive os caminhos anteriores dos
+// arquivos renomeados
+func (m *Manifest) removedPaths() []string {
+	paths := make([]string, 0, len(m.Removed)+len(m.Renamed))
+	for _, entry := range m.Removed {
+		paths = append(paths, entry.Path)
+	}
+	for _, entry := range m.Renamed {
+		paths = append(paths, entry.From)
+	}
+	return paths
+}
+
+// Escreve o manifesto como a primeira entrada do zip
+func writeManifestToZip(zipWriter *zip.Writer, m *Manifest) error {
+	writer, err := zipWriter.Create(manifestEntryName)
+	if err != nil {
+		return err
+	}
+	return json.NewEncoder(writer).Encode(m)
+}
+
+// Lê o manifesto de um zip recebido. Retorna nil se o zip não possuir manifesto.
+func readManifestFromZip(r *zip.Reader) (*Manifest, error) {
+	for _, f := range r.File {
+		if f.Name != manifestEntryName {
+			continue
+		}
+
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer rc.Close()
+
+		raw, err := io.ReadAll(rc)
+		if err != nil {
+			return nil, err
+		}
+
+		var m Manifest
+		err = json.Unmarshal(raw, &m)
+		if err != nil {
+			return nil, fmt.Errorf("erro ao decodificar o manifesto: %v", err)
+		}
+		return &m, nil
+	}
+
+	return nil, nil
+}
@@ -0,0 +1,496 @@
+package tinygit
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+var errObjectsUnsupported = errors.New("servidor não suporta download por objeto")
+
+// O arquivo em disco não é mais o da árvore salva
+var errBlobChanged = errors.New("arquivo alterado desde o último commit")
+
+// Resposta de PullManifestHandler: o manifesto das alterações e a versão
+// assinada do servidor, se houver
+type pullManifestResponse struct {
+	Manifest *Manifest      `json:"manifest"`
+	Release  *SignedRelease `json:"release,omitempty"`
+}
+
+// Índice dos arquivos de uma árvore salva pelo hash dos metadados e do
+// conteúdo, montado uma vez por HEAD. Guarda também o tamanho e a data de
+// modificação dos arquivos já conferidos, para que o conteúdo só seja lido
+// de novo se o arquivo mudar.
+type blobIndex struct {
+	head  string
+	blobs map[string]*Node
+
+	mu       sync.Mutex
+	verified map[string]fileStamp
+}
+
+type fileStamp struct {
+	size    int64
+	modTime time.Time
+}
+
+var (
+	blobIndexesMu sync.Mutex
+	// Índice de cada diretório servido, o principal e os de branches e tags
+	blobIndexes = map[string]*blobIndex{}
+)
+
+// Retorna o índice da árvore n do diretório, refazendo-o se o HEAD mudou
+func treeBlobIndex(rootPath string, n *Node) *blobIndex {
+	blobIndexesMu.Lock()
+	defer blobIndexesMu.Unlock()
+
+	idx := blobIndexes[rootPath]
+	if idx == nil || idx.head != n.Hash {
+		idx = &blobIndex{head: n.Hash, blobs: map[string]*Node{}, verified: map[string]fileStamp{}}
+		idx.add(n)
+		blobIndexes[rootPath] = idx
+	}
+	return idx
+}
+
+func (idx *blobIndex) add(node *Node) {
+	if node.Type == treeType {
+		for _, child := range node.Children {
+			idx.add(child)
+		}
+		return
+	}
+	idx.blobs[strings.ToLower(node.Hash)] = node
+	if node.ContentHash != "" {
+		idx.blobs[strings.ToLower(node.ContentHash)] = node
+	}
+}
+
+// Procura o arquivo com o hash informado, dos metadados ou do conteúdo
+func (idx *blobIndex) find(hash string) *Node {
+	return idx.blobs[strings.ToLower(hash)]
+}
+
+// PullManifestHandler recebe a árvore do cliente e retorna apenas o manifesto
+// das alterações. Os arquivos são baixados individualmente por ObjectHandler.
+func PullManifestHandler(w http.ResponseWriter, r *http.Request, rootPath string, n Node) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Método não permitido", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var tree Node
+	err := json.NewDecoder(r.Body).Decode(&tree)
+	if err != nil {
+		http.Error(w, "Árvore inválida", http.StatusBadRequest)
+		return
+	}
+
+	c := CompareTrees(&tree, &n)
+	if !acceptsRenames(r) {
+		c.foldRenames()
+	}
+
+	m, err := newManifest(rootPath, n.Hash, c)
+	if err != nil {
+		http.Error(w, "Erro ao gerar manifesto", http.StatusInternalServerError)
+		return
+	}
+
+	resp := pullManifestResponse{Manifest: m}
+
+	release, err := readSignedRelease(rootPath)
+	if err != nil {
+		http.Error(w, "Erro ao ler a versão assinada", http.StatusInternalServerError)
+		return
+	}
+	if release != nil {
+		resp.Release = &SignedRelease{}
+		err = json.Unmarshal(release, resp.Release)
+		if err != nil {
+			http.Error(w, "Erro ao ler a versão assinada", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	b, err := json.Marshal(resp)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(b)
+}
+
+// ObjectHandler envia o arquivo cujo hash, de preferência o do conteúdo, é o
+// último segmento do caminho (/objects/{hash}). O arquivo só é enviado se
+// ainda for o da árvore salva; assim a resposta, identificada pelo hash do
+// conteúdo, nunca muda e pode ser guardada em cache por proxies e clientes.
+func ObjectHandler(w http.ResponseWriter, r *http.Request, rootPath string, n Node) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Método não permitido", http.StatusMethodNotAllowed)
+		return
+	}
+
+	hash := path.Base(r.URL.Path)
+	if _, err := hex.DecodeString(hash); err != nil || hash == "" {
+		http.Error(w, "Hash inválido", http.StatusBadRequest)
+		return
+	}
+
+	idx := treeBlobIndex(rootPath, &n)
+	node := idx.find(hash)
+	if node == nil {
+		http.Error(w, "Objeto não encontrado", http.StatusNotFound)
+		return
+	}
+
+	file, err := idx.open(rootPath, node)
+	if errors.Is(err, errBlobChanged) {
+		http.Error(w, "Arquivo alterado desde o último commit", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Objeto não encontrado", http.StatusNotFound)
+		return
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	if node.ContentHash != "" {
+		w.Header().Set("ETag", `"`+node.ContentHash+`"`)
+		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	} else {
+		w.Header().Set("Cache-Control", "no-cache")
+	}
+	http.ServeContent(w, r, "", info.ModTime(), file)
+}
+
+// Abre o arquivo do nó, retornando errBlobChanged se o tamanho ou o conteúdo
+// em disco forem diferentes dos da árvore salva. O conteúdo é lido apenas na
+// primeira vez ou se a data de modificação mudar. Árvores antigas, sem o hash
+// do conteúdo, só têm o tamanho verificado.
+func (idx *blobIndex) open(rootPath string, node *Node) (*os.File, error) {
+	file, err := os.Open(filepath.Join(rootPath, node.Path))
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := file.Stat()
+	if err == nil && info.Size() != node.Size {
+		err = errBlobChanged
+	}
+	stamp := fileStamp{}
+	if err == nil {
+		stamp = fileStamp{size: info.Size(), modTime: info.ModTime()}
+	}
+	if err == nil && node.ContentHash != "" && !idx.isVerified(node.Path, stamp) {
+		var sum []byte
+		sum, err = hashContent(file)
+		if err == nil && !CompareHashes(hex.EncodeToString(sum), node.ContentHash) {
+			err = errBlobChanged
+		}
+		if err == nil {
+			_, err = file.Seek(0, io.SeekStart)
+		}
+		if err == nil {
+			idx.setVerified(node.Path, stamp)
+		}
+	}
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return file, nil
+}
+
+func (idx *blobIndex) isVerified(path string, stamp fileStamp) bool {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	verified, found := idx.verified[path]
+	return found && verified.size == stamp.size && verified.modTime.Equal(stamp.modTime)
+}
+
+func (idx *blobIndex) setVerified(path string, stamp fileStamp) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.verified[path] = stamp
+}
+
+// Identificador usado para baixar o arquivo: o hash do conteúdo, que não
+// depende da data de modificação, ou o hash da árvore em manifestos antigos
+func objectKey(entry ManifestEntry) string {
+	if entry.ContentHash != "" {
+		return entry.ContentHash
+	}
+	return entry.Hash
+}
+
+// Solicita o manifesto do pull ao servidor
+func requestPullManifest(tree []byte, serverUrl string, parameters map[string]string, t *transfer) (*pullManifestResponse, error) {
+	u, err := parseUrlParameter(serverUrl, "pull/manifest", parameters)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, u, bytes.NewReader(tree))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(renamesHeader, "1")
+
+	resp, err := t.doSafe(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusMethodNotAllowed {
+		return nil, errObjectsUnsupported
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, newHTTPError("enviar árvore", resp)
+	}
+
+	var pm pullManifestResponse
+	err = json.NewDecoder(resp.Body).Decode(&pm)
+	if err != nil {
+		return nil, err
+	}
+	if pm.Manifest == nil {
+		return nil, errors.New("resposta sem manifesto")
+	}
+	return &pm, nil
+}
+
+// Baixa para a área de staging cada objeto do manifesto, com até t.jobs
+// downloads simultâneos. Arquivos com o mesmo conteúdo são baixados uma única
+// vez e os resultados são registrados na ordem do manifesto. Arquivos renomeados
+// são copiados do caminho anterior e só são baixados se a cópia local tiver
+// sido alterada.
+func (tx *pullTransaction) stageObjects(m *Manifest, serverUrl string, parameters map[string]string, t *transfer) error {
+	files := m.files()
+	tx.head = m.Head
+
+	for _, entry := range m.Renamed {
+		err := tx.stageRename(entry)
+		if err != nil {
+			logger().Debug("Baixando arquivo renomeado", "caminho", entry.Path, "erro", err)
+			files = append(files, entry)
+			continue
+		}
+		tx.files = append(tx.files, entry.Path)
+	}
+
+	// Arquivos modificados podem ser reconstruídos a partir da cópia local
+	modified := map[string]bool{}
+	for _, entry := range m.Modified {
+		modified[entry.Path] = true
+	}
+
+	paths := make([]string, len(files))
+	first := map[string]int{}
+	var unique []int
+	for i, entry := range files {
+		fpath, err := safeJoin(tx.stagingDir, entry.Path)
+		if err != nil {
+			return err
+		}
+		paths[i] = fpath
+
+		if _, found := first[objectKey(entry)]; !found {
+			first[objectKey(entry)] = i
+			unique = append(unique, i)
+		}
+	}
+
+	var totalBytes int64
+	for _, i := range unique {
+		totalBytes += files[i].Size
+	}
+	t.progress.start(PhaseDownload, totalBytes, len(unique))
+
+	err := t.run(len(unique), func(k int) error {
+		entry, fpath := files[unique[k]], paths[unique[k]]
+
+		var err error
+		if localPath, ok := tx.deltaBase(entry, modified); ok {
+			err = fetchObjectDelta(localPath, fpath, entry, serverUrl, parameters, t)
+			if err != nil {
+				logger().Warn("Erro ao baixar o delta, baixando arquivo completo", "erro", err)
+				err = downloadObject(fpath, entry, serverUrl, parameters, t)
+			}
+		} else {
+			err = downloadObject(fpath, entry, serverUrl, parameters, t)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", entry.Path, err)
+		}
+
+		err = finishStagedFile(fpath, entry)
+		if err != nil {
+			return err
+		}
+		t.progress.add(entry.Size, 1)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	t.progress.finish()
+
+	for i, entry := range files {
+		if src := paths[first[objectKey(entry)]]; src != paths[i] {
+			err = copyFile(src, paths[i])
+			if err != nil {
+				return fmt.Errorf("%s: %w", entry.Path, err)
+			}
+			err = finishStagedFile(paths[i], entry)
+			if err != nil {
+				return err
+			}
+		}
+		tx.files = append(tx.files, entry.Path)
+	}
+
+	tx.remove(m.removedPaths()...)
+	return nil
+}
+
+// Copia para a área de staging a cópia local do arquivo renomeado
+func (tx *pullTransaction) stageRename(entry ManifestEntry) error {
+	src, err := safeJoin(tx.rootPath, entry.From)
+	if err != nil {
+		return err
+	}
+	fpath, err := safeJoin(tx.stagingDir, entry.Path)
+	if err != nil {
+		return err
+	}
+
+	err = copyFile(src, fpath)
+	if err != nil {
+		return err
+	}
+	return finishStagedFile(fpath, entry)
+}
+
+// Restaura a data de modificação do arquivo preparado e verifica seu conteúdo
+func finishStagedFile(fpath string, entry ManifestEntry) error {
+	if entry.ModTime != 0 {
+		modTime := time.Unix(entry.ModTime, 0)
+		err := os.Chtimes(fpath, modTime, modTime)
+		if err != nil {
+			return err
+		}
+	}
+
+	return verifyStagedFile(fpath, entry)
+}
+
+// Retorna a cópia local de um arquivo modificado, se for grande o bastante
+// para valer a transferência por delta
+func (tx *pullTransaction) deltaBase(entry ManifestEntry, modified map[string]bool) (string, bool) {
+	if !modified[entry.Path] || entry.Size < deltaMinSize {
+		return "", false
+	}
+	localPath, err := safeJoin(tx.rootPath, entry.Path)
+	if err != nil {
+		return "", false
+	}
+	info, err := os.Stat(localPath)
+	if err != nil || !info.Mode().IsRegular() || info.Size() < deltaMinSize {
+		return "", false
+	}
+	return localPath, true
+}
+
+// Baixa um objeto, tentando novamente em caso de falha
+func downloadObject(fpath string, entry ManifestEntry, serverUrl string, parameters map[string]string, t *transfer) error {
+	err := os.MkdirAll(filepath.Dir(fpath), os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	failures := 0
+	for {
+		err = fetchObject(fpath, entry, serverUrl, parameters, t)
+		if err == nil {
+			return nil
+		}
+		if t.ctx.Err() != nil {
+			return t.ctx.Err()
+		}
+		failures++
+		if failures > downloadRetries {
+			return err
+		}
+		logger().Warn("Erro ao baixar o objeto, tentando novamente", "erro", err)
+		if err := t.sleep(time.Duration(failures) * time.Second); err != nil {
+			return err
+		}
+	}
+}
+
+func fetchObject(fpath string, entry ManifestEntry, serverUrl string, parameters map[string]string, t *transfer) error {
+	u, err := parseUrlParameter(serverUrl, path.Join("objects", objectKey(entry)), parameters)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := t.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return newHTTPError("baixar o objeto", resp)
+	}
+
+	file, err := os.Create(fpath)
+	if err != nil {
+		return err
+	}
+
+	n, err := io.Copy(file, t.reader(resp.Body))
+	if err != nil {
+		file.Close()
+		return err
+	}
+	err = file.Close()
+	if err != nil {
+		return err
+	}
+
+	if n != entry.Size {
+		return fmt.Errorf("download incompleto: %d de %d bytes", n, entry.Size)
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package tinygit
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestBlobIndexOpen(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, dir, "a.txt", "conteúdo")
+	node := &Node{Path: "a.txt", Hash: "aa", Type: blobType, Size: 9, ContentHash: contentHash("conteúdo")}
+	root := &Node{Hash: "head", Type: treeType, Children: []*Node{node}}
+
+	idx := treeBlobIndex(dir, root)
+	if idx.find(contentHash("conteúdo")) != node || idx.find("AA") != node {
+		t.Fatal("arquivo não encontrado pelo hash")
+	}
+	if idx.find("bb") != nil {
+		t.Error("hash desconhecido encontrado")
+	}
+
+	open := func() error {
+		file, err := idx.open(dir, node)
+		if err == nil {
+			file.Close()
+		}
+		return err
+	}
+	if err := open(); err != nil {
+		t.Fatalf("open: %v", err)
+	}
+
+	// Mesmo tamanho, conteúdo e data de modificação diferentes
+	fpath := filepath.Join(dir, "a.txt")
+	writeTestFile(t, dir, "a.txt", "conteúdx")
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(fpath, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if err := open(); !errors.Is(err, errBlobChanged) {
+		t.Errorf("open: %v, esperado errBlobChanged", err)
+	}
+
+	if treeBlobIndex(dir, root) != idx {
+		t.Error("índice refeito sem mudança de HEAD")
+	}
+	if treeBlobIndex(dir, &Node{Hash: "outro", Type: treeType}) == idx {
+		t.Error("índice não refeito com outro HEAD")
+	}
+}
@@ -0,0 +1,132 @@
+package tinygit
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// ProgressPhase identifica a etapa de uma operação
+type ProgressPhase string
+
+const (
+	// Cálculo dos hashes dos arquivos locais
+	PhaseScan ProgressPhase = "scan"
+	// Geração do zip enviado ao servidor
+	PhaseCompress ProgressPhase = "compress"
+	// Download de arquivos do servidor
+	PhaseDownload ProgressPhase = "download"
+	// Envio de arquivos ao servidor
+	PhaseUpload ProgressPhase = "upload"
+	// Extração e verificação dos arquivos recebidos
+	PhaseExtract ProgressPhase = "extract"
+)
+
+// Progress descreve o andamento da etapa atual. Os totais são zero quando
+// não são conhecidos antecipadamente.
+type Progress struct {
+	Phase      ProgressPhase `json:"phase"`
+	Bytes      int64         `json:"bytes"`
+	TotalBytes int64         `json:"totalBytes"`
+	Files      int           `json:"files"`
+	TotalFiles int           `json:"totalFiles"`
+	Done       bool          `json:"done"`
+}
+
+// ProgressReporter recebe o andamento das operações. Report pode ser chamado
+// de várias goroutines, mas nunca simultaneamente.
+type ProgressReporter interface {
+	Report(p Progress)
+}
+
+// Intervalo mínimo entre dois relatórios da mesma etapa
+const progressInterval = 100 * time.Millisecond
+
+// Acumula o progresso de uma etapa e repassa ao ProgressReporter, limitando
+// a frequência dos relatórios. Um *progress nil ignora todas as chamadas.
+type progress struct {
+	mu       sync.Mutex
+	reporter ProgressReporter
+	current  Progress
+	last     time.Time
+}
+
+func newProgress(reporter ProgressReporter) *progress {
+	if reporter == nil {
+		return nil
+	}
+	return &progress{reporter: reporter}
+}
+
+// Inicia uma nova etapa
+func (p *progress) start(phase ProgressPhase, totalBytes int64, totalFiles int) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.current = Progress{Phase: phase, TotalBytes: totalBytes, TotalFiles: totalFiles}
+	p.last = time.Now()
+	p.reporter.Report(p.current)
+}
+
+// Soma bytes e arquivos concluídos à etapa atual
+func (p *progress) add(bytes int64, files int) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.current.Bytes += bytes
+	p.current.Files += files
+	if time.Since(p.last) >= progressInterval {
+		p.last = time.Now()
+		p.reporter.Report(p.current)
+	}
+}
+
+// Define os bytes já concluídos, como ao retomar um download
+func (p *progress) setBytes(bytes int64) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.current.Bytes = bytes
+}
+
+// Encerra a etapa atual
+func (p *progress) finish() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.current.Done = true
+	p.reporter.Report(p.current)
+}
+
+// Retorna um leitor que soma ao progresso os bytes lidos de r
+func (p *progress) reader(r io.Reader) io.Reader {
+	if p == nil {
+		return r
+	}
+	return &progressReader{r: r, progress: p}
+}
+
+type progressReader struct {
+	r        io.Reader
+	progress *progress
+}
+
+func (pr *progressReader) Read(b []byte) (int, error) {
+	n, err := pr.r.Read(b)
+	if n > 0 {
+		pr.progress.add(int64(n), 0)
+	}
+	return n, err
+}
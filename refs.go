@@ -0,0 +1,348 @@
+package tinygit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	refsDirName      = "refs"
+	branchesDirName  = "heads"
+	tagsDirName      = "tags"
+	worktreesDirName = "worktrees"
+	// Branch criado por Init e Clone
+	DefaultBranch = "main"
+	// Parâmetro das requisições que escolhe o branch ou a tag servida. Sem
+	// ele, o servidor usa o próprio diretório. Refs em outros commits só
+	// podem ser servidas se o repositório do servidor guardar o histórico.
+	RefParam = "ref"
+)
+
+// Ref é um branch ou uma tag: um nome que aponta para um commit. Branches
+// avançam a cada commit feito neles; tags são imutáveis.
+type Ref struct {
+	Name   string `json:"name"`
+	Commit string `json:"commit"`
+	Tag    bool   `json:"tag,omitempty"`
+}
+
+func refPath(rootPath, kind, name string) string {
+	return filepath.Join(rootPath, versionDirName, refsDirName, kind, name)
+}
+
+// Verifica se o nome pode ser usado como branch ou tag
+func validRefName(name string) error {
+	if name == "" || name[0] == '.' || name[0] == '-' || strings.Contains(name, "..") {
+		return fmt.Errorf("nome inválido: %q", name)
+	}
+	switch name {
+	case DiffHead, DiffWorkTree, DiffRemote:
+		return fmt.Errorf("nome reservado: %s", name)
+	}
+	for _, c := range name {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '.' || c == '-' || c == '_') {
+			return fmt.Errorf("nome inválido: %q", name)
+		}
+	}
+	return nil
+}
+
+// Lê o commit para o qual a ref aponta
+func readRef(rootPath, kind, name string) (string, error) {
+	if validRefName(name) != nil {
+		return "", fmt.Errorf("%w: %s", ErrNotFound, name)
+	}
+	b, err := os.ReadFile(refPath(rootPath, kind, name))
+	if os.IsNotExist(err) {
+		return "", fmt.Errorf("%w: %s", ErrNotFound, name)
+	}
+	if err != nil {
+		return "", fmt.Errorf("erro ao ler %s: %v", name, err)
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+// Grava a ref apontando para o commit, substituindo-a de uma vez
+func writeRef(rootPath, kind, name, id string) error {
+	dir := filepath.Join(rootPath, versionDirName, refsDirName)
+	if err := os.MkdirAll(filepath.Join(dir, kind), 0700); err != nil {
+		return fmt.Errorf("erro ao criar diretório de refs: %v", err)
+	}
+
+	// O arquivo temporário fica fora de heads e tags para não ser listado
+	tmp, err := os.CreateTemp(dir, name+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("erro ao gravar %s: %v", name, err)
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.WriteString(id + "\n")
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("erro ao gravar %s: %v", name, err)
+	}
+	return os.Rename(tmp.Name(), refPath(rootPath, kind, name))
+}
+
+// Lista as refs do tipo informado, ordenadas pelo nome
+func listRefs(rootPath, kind string) ([]Ref, error) {
+	entries, err := os.ReadDir(filepath.Join(rootPath, versionDirName, refsDirName, kind))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	refs := []Ref{}
+	for _, entry := range entries {
+		id, err := readRef(rootPath, kind, entry.Name())
+		if err != nil {
+			continue
+		}
+		refs = append(refs, Ref{Name: entry.Name(), Commit: id, Tag: kind == tagsDirName})
+	}
+	sort.Slice(refs, func(i, j int) bool { return refs[i].Name < refs[j].Name })
+	return refs, nil
+}
+
+// Encontra o commit do branch ou da tag, indicando se é um branch. Retorna
+// ErrNotFound se não houver nenhum dos dois com o nome.
+func resolveRef(rootPath, name string) (string, bool, error) {
+	id, err := readRef(rootPath, branchesDirName, name)
+	if err == nil {
+		return id, true, nil
+	}
+	if !errors.Is(err, ErrNotFound) {
+		return "", false, err
+	}
+	id, err = readRef(rootPath, tagsDirName, name)
+	return id, false, err
+}
+
+// Encontra o commit de um branch, de uma tag ou de um prefixo de commit
+func resolveRevision(rootPath, name string) (string, error) {
+	id, _, err := resolveRef(rootPath, name)
+	if err == nil || !errors.Is(err, ErrNotFound) {
+		return id, err
+	}
+	return resolveCommit(rootPath, name)
+}
+
+// Branch retorna o branch atual, vazio se o commit atual não estiver em um
+// branch
+func (r *Repository) Branch() string {
+	return r.v.Branch
+}
+
+// Branches retorna os branches do repositório, ordenados pelo nome
+func (r *Repository) Branches() ([]Ref, error) {
+	return listRefs(r.path, branchesDirName)
+}
+
+// Tags retorna as tags do repositório, ordenadas pelo nome
+func (r *Repository) Tags() ([]Ref, error) {
+	return listRefs(r.path, tagsDirName)
+}
+
+// CreateBranch cria um branch no commit, branch ou tag rev, ou no commit
+// atual se rev for vazio. Retorna ErrRefExists se já houver um branch ou
+// uma tag com o nome e ErrNoHistory se o repositório não guardar o
+// histórico ou o commit tiver sido salvo sem ele.
+func (r *Repository) CreateBranch(name, rev string) error {
+	return r.createRef(branchesDirName, name, rev)
+}
+
+// CreateTag cria uma tag no commit, branch ou tag rev, ou no commit atual se
+// rev for vazio. Tags não podem ser movidas: retorna ErrRefExists se já
+// houver um branch ou uma tag com o nome. Como CreateBranch, exige o
+// histórico.
+func (r *Repository) CreateTag(name, rev string) error {
+	return r.createRef(tagsDirName, name, rev)
+}
+
+func (r *Repository) createRef(kind, name, rev string) error {
+	if err := validRefName(name); err != nil {
+		return err
+	}
+	// Sem o conteúdo dos commits, a ref não poderia ser restaurada por
+	// Switch nem servida depois que o commit atual mudasse
+	if !r.v.History {
+		return fmt.Errorf("%w: branches e tags exigem o histórico, ativado com --history no init ou no clone", ErrNoHistory)
+	}
+	if exists(refPath(r.path, branchesDirName, name)) || exists(refPath(r.path, tagsDirName, name)) {
+		return fmt.Errorf("%w: %s", ErrRefExists, name)
+	}
+
+	id := r.v.Commit
+	if rev != "" {
+		var err error
+		id, err = resolveRevision(r.path, rev)
+		if err != nil {
+			return err
+		}
+	}
+	if id == "" {
+		return fmt.Errorf("nenhum commit salvo")
+	}
+	rec, err := readCommit(r.path, id)
+	if err != nil {
+		return err
+	}
+	if !rec.hasContent() {
+		return fmt.Errorf("%w: commit %s salvo antes de o histórico ser ativado", ErrNoHistory, rec.ID)
+	}
+
+	logger().Info("Criando ref", "nome", name, "commit", id)
+	return writeRef(r.path, kind, name, id)
+}
+
+// DeleteBranch remove o branch, sem remover seus commits. O branch atual não
+// pode ser removido.
+func (r *Repository) DeleteBranch(name string) error {
+	if _, err := readRef(r.path, branchesDirName, name); err != nil {
+		return err
+	}
+	if name == r.v.Branch {
+		return fmt.Errorf("não é possível remover o branch atual: %s", name)
+	}
+	return os.Remove(refPath(r.path, branchesDirName, name))
+}
+
+// Switch restaura no diretório de trabalho o commit do branch ou da tag.
+// Em um branch, os próximos commits o avançam; em uma tag, o repositório
+// fica sem branch. Retorna ErrUncommittedChanges se houver alterações não
+// salvas e o commit for diferente do atual.
+func (r *Repository) Switch(ctx context.Context, name string) error {
+	id, isBranch, err := resolveRef(r.path, name)
+	if err != nil {
+		return err
+	}
+	if !isBranch {
+		name = ""
+	}
+	return r.checkout(ctx, id, name)
+}
+
+// Diretório com os arquivos do branch ou da tag ref, usado pelo servidor.
+// Sem ref, ou se ele apontar para o commit atual, é o próprio rootPath; senão
+// os arquivos do commit são extraídos uma única vez em
+// .tinygit/worktrees/<commit>, com o arquivo de versão do commit. Retorna
+// ErrNoHistory se o commit tiver sido salvo sem o conteúdo dos arquivos.
+func refWorktree(rootPath, ref string) (string, error) {
+	if ref == "" {
+		return rootPath, nil
+	}
+	id, _, err := resolveRef(rootPath, ref)
+	if err != nil {
+		return "", err
+	}
+	v, err := decompressVersionFile(rootPath)
+	if err != nil {
+		return "", err
+	}
+	if id == v.Commit {
+		return rootPath, nil
+	}
+
+	dir := filepath.Join(rootPath, versionDirName, worktreesDirName, id)
+	if exists(dir) {
+		return dir, nil
+	}
+
+	rec, err := readCommit(rootPath, id)
+	if err != nil {
+		return "", err
+	}
+	if !rec.hasContent() {
+		return "", fmt.Errorf("%w: commit %s", ErrNoHistory, rec.ID)
+	}
+	if err := os.MkdirAll(filepath.Dir(dir), 0700); err != nil {
+		return "", err
+	}
+	tmp, err := os.MkdirTemp(filepath.Dir(dir), id+".*.tmp")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(tmp)
+
+	logger().Info("Extraindo arquivos do commit", "ref", ref, "commit", id)
+	for _, entry := range rec.Files {
+		err = restoreObject(rootPath, entry, filepath.Join(tmp, entry.Path))
+		if err != nil {
+			return "", fmt.Errorf("erro ao restaurar %s: %w", entry.Path, err)
+		}
+	}
+
+	err = generateVersionFile(tmp, &Versioning{
+		ExtensionsToGenerateVersion: v.ExtensionsToGenerateVersion,
+		Head:                        rec.Head,
+		Tree:                        rec.Tree,
+		Commit:                      rec.ID,
+	})
+	if err != nil {
+		return "", err
+	}
+	err = copySignedRelease(rootPath, tmp, rec.Head)
+	if err != nil {
+		return "", err
+	}
+
+	err = os.Rename(tmp, dir)
+	if err != nil {
+		// Outra requisição pode ter extraído o mesmo commit
+		if exists(dir) {
+			return dir, nil
+		}
+		return "", err
+	}
+	return dir, nil
+}
+
+// Copia a versão assinada para o diretório extraído se ela for do mesmo
+// commit
+func copySignedRelease(rootPath, dir, head string) error {
+	b, err := readSignedRelease(rootPath)
+	if err != nil || b == nil {
+		return err
+	}
+
+	var sr SignedRelease
+	var release Release
+	if json.Unmarshal(b, &sr) != nil || json.Unmarshal(sr.Release, &release) != nil || !release.matchesHead(head) {
+		return nil
+	}
+	return os.WriteFile(filepath.Join(dir, versionDirName, releaseFileName), b, 0644)
+}
+
+// Remove os diretórios extraídos de commits que não são mais apontados por
+// nenhum branch ou tag, e as extrações interrompidas. Requisições em
+// andamento podem estar lendo um diretório extraído, por isso só é chamada
+// quando o servidor inicia, antes de atender a primeira.
+func pruneWorktrees(rootPath string) {
+	referenced := map[string]bool{}
+	for _, kind := range []string{branchesDirName, tagsDirName} {
+		refs, err := listRefs(rootPath, kind)
+		if err != nil {
+			return
+		}
+		for _, ref := range refs {
+			referenced[ref.Commit] = true
+		}
+	}
+
+	dir := filepath.Join(rootPath, versionDirName, worktreesDirName)
+	entries, _ := os.ReadDir(dir)
+	for _, entry := range entries {
+		if referenced[entry.Name()] {
+			continue
+		}
+		logger().Debug("Removendo commit extraído", "commit", entry.Name())
+		os.RemoveAll(filepath.Join(dir, entry.Name()))
+	}
+}
@@ -0,0 +1,34 @@
+package tinygit
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestCreateRefRequiresHistory(t *testing.T) {
+	r := newTestRepo(t, testFiles)
+	if err := r.CreateBranch("dev", ""); !errors.Is(err, ErrNoHistory) {
+		t.Errorf("CreateBranch sem histórico: %v, esperado ErrNoHistory", err)
+	}
+	if err := r.CreateTag("v1", ""); !errors.Is(err, ErrNoHistory) {
+		t.Errorf("CreateTag sem histórico: %v, esperado ErrNoHistory", err)
+	}
+
+	// O commit atual foi salvo antes de o histórico ser ativado
+	r, err := OpenWithOptions(r.path, Options{History: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.CreateTag("v1", ""); !errors.Is(err, ErrNoHistory) {
+		t.Errorf("CreateTag em commit sem conteúdo: %v, esperado ErrNoHistory", err)
+	}
+
+	writeTestFile(t, r.path, "a.txt", "a2")
+	if _, err := r.Commit(context.Background(), "com histórico"); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.CreateTag("v1", ""); err != nil {
+		t.Errorf("CreateTag: %v", err)
+	}
+}
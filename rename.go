@@ -0,0 +1,231 @@
+package tinygit
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+const (
+	// Cabeçalho enviado pelos clientes que aplicam renomeações. Sem ele, o
+	// servidor informa os arquivos renomeados como adicionados e removidos.
+	renamesHeader = "Tinygit-Renames"
+	// Tamanho máximo dos arquivos comparados por similaridade
+	maxRenameSimilaritySize = 64 << 10
+	// Similaridade mínima, em porcentagem, para considerar um arquivo
+	// renomeado
+	minRenameSimilarity = 50
+)
+
+// Rename é um arquivo movido ou renomeado entre as árvores
+type Rename struct {
+	OldPath string `json:"oldPath"`
+	NewPath string `json:"newPath"`
+	// Porcentagem do conteúdo em comum, 100 se for idêntico
+	Similarity int `json:"similarity"`
+	// Nó do arquivo no novo caminho
+	Node *Node `json:"node"`
+
+	// Nó do arquivo no caminho antigo
+	old *Node
+}
+
+// Move para Renamed os arquivos removidos e adicionados com o mesmo conteúdo.
+// Entre arquivos com o mesmo conteúdo, prefere os de mesmo nome.
+func (c *Changes) detectRenames() {
+	removed := map[string][]*Node{}
+	for _, node := range sortedBlobs(c.Removed) {
+		if node.ContentHash != "" {
+			removed[node.ContentHash] = append(removed[node.ContentHash], node)
+		}
+	}
+	if len(removed) == 0 {
+		return
+	}
+
+	oldPaths, newPaths := map[string]bool{}, map[string]bool{}
+	for _, node := range sortedBlobs(c.Added) {
+		var match *Node
+		for _, candidate := range removed[node.ContentHash] {
+			if oldPaths[candidate.Path] {
+				continue
+			}
+			if match == nil || filepath.Base(candidate.Path) == filepath.Base(node.Path) && filepath.Base(match.Path) != filepath.Base(node.Path) {
+				match = candidate
+			}
+		}
+		if match == nil {
+			continue
+		}
+
+		oldPaths[match.Path] = true
+		newPaths[node.Path] = true
+		c.Renamed = append(c.Renamed, Rename{OldPath: match.Path, NewPath: node.Path, Similarity: 100, Node: node, old: match})
+	}
+
+	c.removeRenamed(oldPaths, newPaths)
+}
+
+// Move para Renamed os arquivos removidos e adicionados pequenos cujo
+// conteúdo tenha pelo menos minRenameSimilarity% em comum. read retorna o
+// conteúdo do arquivo removido (old) ou adicionado, ou false se não estiver
+// disponível.
+func (c *Changes) detectSimilarRenames(read func(node *Node, old bool) ([]byte, bool)) {
+	contents := func(nodes []*Node, old bool) ([]*Node, [][]byte) {
+		var found []*Node
+		var data [][]byte
+		for _, node := range sortedBlobs(nodes) {
+			if node.Size > maxRenameSimilaritySize {
+				continue
+			}
+			if content, ok := read(node, old); ok {
+				found = append(found, node)
+				data = append(data, content)
+			}
+		}
+		return found, data
+	}
+
+	removed, removedData := contents(c.Removed, true)
+	if len(removed) == 0 {
+		return
+	}
+	added, addedData := contents(c.Added, false)
+
+	oldPaths, newPaths := map[string]bool{}, map[string]bool{}
+	for i, node := range added {
+		best, bestScore := -1, minRenameSimilarity-1
+		for j, candidate := range removed {
+			if oldPaths[candidate.Path] {
+				continue
+			}
+			if score := similarity(removedData[j], addedData[i]); score > bestScore {
+				best, bestScore = j, score
+			}
+		}
+		if best < 0 {
+			continue
+		}
+
+		oldPaths[removed[best].Path] = true
+		newPaths[node.Path] = true
+		c.Renamed = append(c.Renamed, Rename{OldPath: removed[best].Path, NewPath: node.Path, Similarity: bestScore, Node: node, old: removed[best]})
+	}
+
+	c.removeRenamed(oldPaths, newPaths)
+}
+
+// Procura renomeações com alterações entre a árvore salva e o diretório de
+// trabalho, lendo o conteúdo salvo do commit atual
+func (r *Repository) detectSimilarRenames(c *Changes) {
+	saved := map[string]ManifestEntry{}
+	if r.v.Commit != "" {
+		if rec, err := readCommit(r.path, r.v.Commit); err == nil {
+			for _, entry := range rec.Files {
+				saved[entry.Path] = entry
+			}
+		}
+	}
+
+	c.detectSimilarRenames(func(node *Node, old bool) ([]byte, bool) {
+		var rc io.ReadCloser
+		var err error
+		if old {
+			entry, found := saved[node.Path]
+			if !found {
+				return nil, false
+			}
+			rc, err = openObject(r.path, entry)
+		} else {
+			rc, err = os.Open(filepath.Join(r.path, node.Path))
+		}
+		if err != nil {
+			return nil, false
+		}
+		defer rc.Close()
+
+		content, err := io.ReadAll(io.LimitReader(rc, maxRenameSimilaritySize+1))
+		return content, err == nil && len(content) <= maxRenameSimilaritySize
+	})
+}
+
+// Retira de Added e Removed os arquivos renomeados
+func (c *Changes) removeRenamed(oldPaths, newPaths map[string]bool) {
+	if len(oldPaths) == 0 {
+		return
+	}
+	c.Added = withoutPaths(c.Added, newPaths)
+	c.Removed = withoutPaths(c.Removed, oldPaths)
+}
+
+// Devolve os arquivos renomeados para Added e Removed, para clientes que não
+// aplicam renomeações
+func (c *Changes) foldRenames() {
+	for _, rename := range c.Renamed {
+		old := rename.old
+		if old == nil {
+			old = &Node{Path: rename.OldPath, Type: blobType}
+		}
+		c.Added = append(c.Added, rename.Node)
+		c.Removed = append(c.Removed, old)
+	}
+	c.Renamed = nil
+	c.sort()
+}
+
+// Indica se o cliente que fez a requisição aplica renomeações
+func acceptsRenames(r *http.Request) bool {
+	return r.Header.Get(renamesHeader) != ""
+}
+
+func sortedBlobs(nodes []*Node) []*Node {
+	var blobs []*Node
+	for _, node := range nodes {
+		blobs = appendBlobs(blobs, node)
+	}
+	sort.Slice(blobs, func(i, j int) bool { return blobs[i].Path < blobs[j].Path })
+	return blobs
+}
+
+func appendBlobs(blobs []*Node, node *Node) []*Node {
+	if node.Type != treeType {
+		return append(blobs, node)
+	}
+	for _, child := range node.Children {
+		blobs = appendBlobs(blobs, child)
+	}
+	return blobs
+}
+
+func withoutPaths(nodes []*Node, paths map[string]bool) []*Node {
+	result := []*Node{}
+	for _, node := range nodes {
+		if !paths[node.Path] {
+			result = append(result, node)
+		}
+	}
+	return result
+}
+
+// Porcentagem do conteúdo em comum, medida pelas linhas presentes nos dois
+// arquivos
+func similarity(a, b []byte) int {
+	if len(a)+len(b) == 0 {
+		return 100
+	}
+
+	counts := map[string]int{}
+	for _, line := range splitLines(a) {
+		counts[line]++
+	}
+	common := 0
+	for _, line := range splitLines(b) {
+		if counts[line] > 0 {
+			counts[line]--
+			common += len(line)
+		}
+	}
+	return common * 2 * 100 / (len(a) + len(b))
+}
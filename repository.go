@@ -0,0 +1,613 @@
+package tinygit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Options configura um Repository
+type Options struct {
+	// Extensões versionadas, somadas às já salvas no repositório
+	Extensions []string
+	// Nomes de arquivos e diretórios ignorados
+	Ignore []string
+	// Opções das transferências de Clone, Pull e Push
+	Transfer TransferOptions
+	// Tratamento dos arquivos alterados localmente e no servidor durante o Pull
+	Conflict ConflictPolicy
+	// Guarda o conteúdo dos arquivos de cada commit em .tinygit/objects, o
+	// que permite Checkout, Switch, Diff entre commits e servir branches e
+	// tags. Uma vez ativado, fica salvo no repositório. Sem ele, os commits
+	// guardam apenas a árvore.
+	History bool
+}
+
+// Repository é um diretório com controle de versão. O arquivo de versão e o
+// índice são lidos uma vez, ao abrir, e mantidos atualizados pelos métodos.
+type Repository struct {
+	path string
+	opts Options
+	v    *Versioning
+	// Arquivos preparados para o commit, nil se não houver
+	index *indexRecord
+}
+
+// Init inicializa o controle de versão em path e salva o primeiro commit.
+// Com opts.History, o conteúdo de cada commit é guardado, o que permite
+// Checkout, branches e tags; sem ele, apenas o commit atual pode ser
+// restaurado ou servido. Retorna ErrAlreadyInitialized se o diretório já
+// tiver controle de versão.
+func Init(path string, opts Options) (*Repository, error) {
+	return InitContext(context.Background(), path, opts)
+}
+
+// InitContext inicializa o controle de versão. O cálculo dos hashes é
+// interrompido se ctx for cancelado.
+func InitContext(ctx context.Context, path string, opts Options) (*Repository, error) {
+	if VerifyIfExistVersionControl(path) {
+		return nil, ErrAlreadyInitialized
+	}
+	err := generateVersionDir(path)
+	if err != nil {
+		logger().Error("Erro ao criar diretório de versão", "erro", err)
+		return nil, err
+	}
+
+	logger().Info("Controle de versão inicializado", "caminho", path)
+	r := &Repository{path: path, opts: opts, v: &Versioning{Branch: DefaultBranch}}
+	r.mergeOptions()
+
+	tree, err := buildTreeWithProgress(ctx, path, &r.v.ExtensionsToGenerateVersion, &r.v.ignoredFiles, nil)
+	if err != nil {
+		logger().Error("Erro ao construir a árvore", "erro", err)
+		return nil, err
+	}
+	if tree == nil {
+		logger().Info("A árvore está vazia")
+		tree = &Node{
+			Hash: "",
+		}
+	}
+
+	_, err = r.save(ctx, tree, "Versão inicial")
+	if err != nil {
+		logger().Error("Erro ao salvar a árvore", "erro", err)
+		return nil, err
+	}
+	return r, nil
+}
+
+// Open abre o repositório em path. Retorna ErrNotInitialized se o diretório
+// não tiver controle de versão.
+func Open(path string) (*Repository, error) {
+	return OpenWithOptions(path, Options{})
+}
+
+// OpenWithOptions abre o repositório somando opts.Extensions e opts.Ignore
+// às configurações salvas
+func OpenWithOptions(path string, opts Options) (*Repository, error) {
+	if !VerifyIfExistVersionControl(path) {
+		return nil, ErrNotInitialized
+	}
+
+	v, err := decompressVersionFile(path)
+	if err != nil {
+		logger().Error("Erro ao ler a árvore salva", "erro", err)
+		return nil, fmt.Errorf("erro ao ler a árvore salva: %w", err)
+	}
+
+	index, err := readIndex(path)
+	if err != nil {
+		logger().Error("Erro ao ler o índice", "erro", err)
+		return nil, err
+	}
+
+	// Grava a árvore com os hashes recalculados ao ler, para que as próximas
+	// leituras não precisem recalculá-los
+	if v.TreeVersion < treeHashVersion {
+		logger().Info("Atualizando o hash da árvore salva", "head", v.Head)
+		err = generateVersionFile(path, v)
+		if err != nil {
+			return nil, fmt.Errorf("erro ao salvar a árvore: %w", err)
+		}
+	}
+
+	r := &Repository{path: path, opts: opts, v: v, index: index}
+	r.mergeOptions()
+	return r, nil
+}
+
+// Clone baixa o repositório do servidor para path e salva o primeiro commit.
+// O download e a geração da árvore são interrompidos se ctx for cancelado.
+// opts.History vale para o clone como em Init: o histórico do servidor não
+// é copiado, apenas os commits seguintes são guardados. Retorna
+// ErrAlreadyInitialized se o diretório já tiver controle de versão.
+func Clone(ctx context.Context, path string, server string, params map[string]string, opts Options) (*Repository, error) {
+	if VerifyIfExistVersionControl(path) {
+		return nil, ErrAlreadyInitialized
+	}
+	err := generateVersionDir(path)
+	if err != nil {
+		logger().Error("Erro ao criar diretório de versão", "erro", err)
+		return nil, err
+	}
+	logger().Info("Controle de versão inicializado", "caminho", path)
+	logger().Info("Clonando repositório")
+
+	t := newTransfer(ctx, opts.Transfer)
+	v, err := requestClone(path, server, params, t)
+
+	if err != nil {
+		logger().Error("Erro ao clonar o repositório", "erro", err)
+		return nil, err
+	}
+
+	if v == nil {
+		return nil, fmt.Errorf("extensões não informadas")
+	}
+	v.Branch = DefaultBranch
+
+	r := &Repository{path: path, opts: opts, v: v}
+	r.mergeOptions()
+
+	logger().Info("Repositório clonado, gerando árvore de versionamento")
+	tree, err := buildTreeWithProgress(ctx, path, &v.ExtensionsToGenerateVersion, &v.ignoredFiles, t.progress)
+	if err != nil {
+		logger().Error("Erro ao construir a árvore", "erro", err)
+		return nil, err
+	}
+	if tree == nil {
+		logger().Info("A árvore está vazia")
+		return r, nil
+	}
+
+	logger().Info("Salvando árvore de versionamento")
+	_, err = r.save(ctx, tree, "Clone de "+server)
+	if err != nil {
+		logger().Error("Erro ao salvar a árvore", "erro", err)
+		return nil, err
+	}
+	return r, nil
+}
+
+// Soma as extensões, os arquivos ignorados e o histórico das opções aos do
+// repositório
+func (r *Repository) mergeOptions() {
+	if diff := CompareSlices(r.v.ignoredFiles, r.opts.Ignore); len(diff) > 0 {
+		r.v.ignoredFiles = append(r.v.ignoredFiles, diff...)
+	}
+	if diff := CompareSlices(r.v.ExtensionsToGenerateVersion, r.opts.Extensions); len(diff) > 0 {
+		r.v.ExtensionsToGenerateVersion = append(r.v.ExtensionsToGenerateVersion, diff...)
+	}
+	if r.opts.History {
+		r.v.History = true
+	}
+}
+
+// Path retorna o diretório do repositório
+func (r *Repository) Path() string {
+	return r.path
+}
+
+// Head retorna o hash da árvore salva
+func (r *Repository) Head() string {
+	return r.v.Head
+}
+
+// Tree retorna a árvore salva
+func (r *Repository) Tree() *Node {
+	return &r.v.Tree
+}
+
+// Constrói a árvore atual do diretório de trabalho
+func (r *Repository) buildTree(ctx context.Context, p *progress) (*Node, error) {
+	return buildTreeWithProgress(ctx, r.path, &r.v.ExtensionsToGenerateVersion, &r.v.ignoredFiles, p)
+}
+
+// Commit salva com a mensagem informada os arquivos preparados com Add ou,
+// se não houver nenhum, a árvore atual. Se paths for informado, os arquivos
+// nesses caminhos (ou abaixo deles, no caso de diretórios) são preparados
+// antes do commit e os demais permanecem como no índice. Retorna
+// ErrNoChanges se não houver alterações a salvar.
+func (r *Repository) Commit(ctx context.Context, message string, paths ...string) (*Commit, error) {
+	s, current, err := r.status(ctx)
+	if err != nil {
+		logger().Error("Erro ao verificar o status", "erro", err)
+		return nil, err
+	}
+
+	if s.Clean {
+		return nil, ErrNoChanges
+	}
+
+	tree := current
+	if len(paths) > 0 {
+		selected, err := r.relativePaths(paths)
+		if err != nil {
+			return nil, err
+		}
+		tree, err = selectTree(r.stagedTree(), current, selected)
+		if err != nil {
+			return nil, err
+		}
+	} else if r.index != nil {
+		tree = &r.index.Tree
+	}
+	if tree == nil {
+		tree = &Node{Hash: ""}
+	}
+	if CompareHashes(tree.Hash, r.v.Head) {
+		return nil, ErrNoChanges
+	}
+
+	logger().Info("Salvando árvore de versionamento")
+	commit, err := r.save(ctx, tree, message)
+	if err != nil {
+		logger().Error("Erro ao salvar a árvore", "erro", err)
+		return nil, err
+	}
+
+	return commit, nil
+}
+
+// Converte os caminhos informados em caminhos relativos à raiz do repositório
+func (r *Repository) relativePaths(paths []string) ([]string, error) {
+	root, err := filepath.Abs(r.path)
+	if err != nil {
+		return nil, err
+	}
+
+	rel := make([]string, 0, len(paths))
+	for _, p := range paths {
+		if filepath.IsAbs(p) {
+			p, err = filepath.Rel(root, p)
+			if err != nil {
+				return nil, err
+			}
+		}
+		p = filepath.Clean(p)
+		if p == ".." || strings.HasPrefix(p, ".."+string(filepath.Separator)) {
+			return nil, fmt.Errorf("caminho fora do repositório: %s", p)
+		}
+		rel = append(rel, p)
+	}
+	return rel, nil
+}
+
+// Guarda o conteúdo da árvore em .tinygit/objects e retorna a lista de
+// arquivos do commit ou do índice, vazia se o histórico estiver desativado
+func (r *Repository) storeTree(ctx context.Context, tree *Node) ([]ManifestEntry, error) {
+	if !r.v.History {
+		return nil, nil
+	}
+	known, err := r.knownFiles()
+	if err != nil {
+		return nil, err
+	}
+	return storeObjects(ctx, r.path, tree, known...)
+}
+
+// Arquivos cujo conteúdo já está em .tinygit/objects: os do commit atual e
+// os do índice
+func (r *Repository) knownFiles() ([][]ManifestEntry, error) {
+	var known [][]ManifestEntry
+	if r.v.Commit != "" {
+		parent, err := readCommit(r.path, r.v.Commit)
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			return nil, err
+		}
+		if parent != nil {
+			known = append(known, parent.Files)
+		}
+	}
+	if r.index != nil {
+		known = append(known, r.index.Files)
+	}
+	return known, nil
+}
+
+// Registra a árvore como um novo commit, guardando o conteúdo dos arquivos,
+// e a salva como a árvore atual do repositório, avançando o branch atual e
+// descartando o índice
+func (r *Repository) save(ctx context.Context, tree *Node, message string) (*Commit, error) {
+	files, err := r.storeTree(ctx, tree)
+	if err != nil {
+		return nil, err
+	}
+
+	rec := &commitRecord{
+		Commit: Commit{
+			Parent:  r.v.Commit,
+			Head:    tree.Hash,
+			Message: message,
+			Time:    time.Now(),
+		},
+		Tree:  *tree,
+		Files: files,
+	}
+	rec.ID = newCommitID(&rec.Commit)
+
+	err = writeCommit(r.path, rec)
+	if err != nil {
+		return nil, err
+	}
+
+	v := *r.v
+	v.Head = tree.Hash
+	v.Tree = *tree
+	v.Commit = rec.ID
+	err = generateVersionFile(r.path, &v)
+	if err != nil {
+		return nil, err
+	}
+	r.v = &v
+
+	if v.Branch != "" {
+		err = writeRef(r.path, branchesDirName, v.Branch, rec.ID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = removeIndex(r.path)
+	if err != nil {
+		return nil, err
+	}
+	r.index = nil
+	return &rec.Commit, nil
+}
+
+// Log retorna os commits do repositório, do atual ao primeiro
+func (r *Repository) Log() ([]Commit, error) {
+	commits := []Commit{}
+	for id := r.v.Commit; id != ""; {
+		rec, err := readCommit(r.path, id)
+		if err != nil {
+			return nil, err
+		}
+		commits = append(commits, rec.Commit)
+		id = rec.Parent
+	}
+	return commits, nil
+}
+
+// Checkout restaura no diretório de trabalho os arquivos do commit cujo
+// identificador começa com id, ou do branch ou da tag com esse nome, e o
+// torna o commit atual, fora de qualquer branch. Retorna
+// ErrUncommittedChanges se houver alterações não salvas.
+func (r *Repository) Checkout(ctx context.Context, id string) error {
+	id, err := resolveRevision(r.path, id)
+	if err != nil {
+		return err
+	}
+	return r.checkout(ctx, id, "")
+}
+
+// Restaura o commit e o torna o atual no branch informado. Se o commit já
+// for o atual, apenas troca o branch, mantendo as alterações não salvas.
+func (r *Repository) checkout(ctx context.Context, id, branch string) error {
+	rec, err := readCommit(r.path, id)
+	if err != nil {
+		return err
+	}
+	if rec.ID == r.v.Commit {
+		v := *r.v
+		v.Branch = branch
+		err = generateVersionFile(r.path, &v)
+		if err != nil {
+			return fmt.Errorf("erro ao salvar a árvore: %w", err)
+		}
+		r.v = &v
+		return nil
+	}
+	if !rec.hasContent() {
+		return fmt.Errorf("%w: commit %s", ErrNoHistory, rec.ID)
+	}
+
+	s, err := r.Status(ctx)
+	if err != nil {
+		return err
+	}
+	if !s.Clean {
+		return ErrUncommittedChanges
+	}
+
+	err = recoverPullTransaction(r.path)
+	if err != nil {
+		return fmt.Errorf("erro ao desfazer atualização interrompida: %w", err)
+	}
+	tx, err := newPullTransaction(r.path)
+	if err != nil {
+		return err
+	}
+	defer tx.cleanup()
+
+	current := map[string]string{}
+	for _, entry := range appendTreeFiles(nil, &r.v.Tree) {
+		current[entry.Path] = entry.Hash
+	}
+
+	logger().Info("Restaurando arquivos do commit", "commit", rec.ID)
+	for _, entry := range rec.Files {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		hash, found := current[entry.Path]
+		delete(current, entry.Path)
+		if found && hash == entry.Hash {
+			continue
+		}
+
+		err = restoreObject(r.path, entry, filepath.Join(tx.stagingDir, entry.Path))
+		if err != nil {
+			return fmt.Errorf("erro ao restaurar %s: %w", entry.Path, err)
+		}
+		tx.files = append(tx.files, entry.Path)
+	}
+	for path := range current {
+		tx.remove(path)
+	}
+
+	err = tx.apply()
+	if err != nil {
+		return fmt.Errorf("erro ao aplicar os arquivos: %w", err)
+	}
+	removeEmptyDirs(r.path, tx.removed)
+
+	v := *r.v
+	v.Head = rec.Tree.Hash
+	v.Tree = rec.Tree
+	v.Commit = rec.ID
+	v.Branch = branch
+	err = generateVersionFile(r.path, &v)
+	if err != nil {
+		return rollbackPull(tx, fmt.Errorf("erro ao salvar a árvore: %w", err))
+	}
+
+	r.v = &v
+	return nil
+}
+
+// Remove os diretórios que ficaram vazios após remover os arquivos
+func removeEmptyDirs(rootPath string, removed []string) {
+	for _, rel := range removed {
+		for dir := filepath.Dir(rel); dir != "." && dir != string(filepath.Separator); dir = filepath.Dir(dir) {
+			if os.Remove(filepath.Join(rootPath, dir)) != nil {
+				break
+			}
+		}
+	}
+}
+
+// Pull atualiza o repositório com a versão do servidor e a registra como um
+// novo commit. Se ctx for cancelado antes de os arquivos serem aplicados,
+// nada é alterado; depois disso, a atualização é desfeita. Retorna
+// ErrUpToDate se não houver nada a baixar e ErrUncommittedChanges se houver
+// arquivos preparados para o commit.
+func (r *Repository) Pull(ctx context.Context, server string, parameter map[string]string) error {
+	if r.index != nil {
+		return ErrUncommittedChanges
+	}
+
+	err := recoverPullTransaction(r.path)
+	if err != nil {
+		logger().Error("Erro ao desfazer atualização interrompida", "erro", err)
+		return fmt.Errorf("erro ao desfazer atualização interrompida: %w", err)
+	}
+
+	logger().Info("Atualizando repositório")
+	vCurrent := r.v
+
+	t := newTransfer(ctx, r.opts.Transfer)
+
+	logger().Info("Enviando HEAD para o servidor", "head", vCurrent.Head)
+	err = sendHeadOfVersion(vCurrent.Head, server, parameter, t)
+	if err != nil {
+		return err
+	}
+
+	logger().Info("Repositório atualizado, gerando árvore de versionamento")
+	tx, err := sendTreeOfVersionForUpdate(r.path, &vCurrent.Tree, server, parameter, t)
+	if err != nil {
+		logger().Error("Erro ao enviar a árvore", "erro", err)
+		return fmt.Errorf("erro ao enviar a árvore: %w", err)
+	}
+	defer tx.cleanup()
+
+	logger().Info("Verificando alterações locais")
+	localTree, err := r.buildTree(ctx, t.progress)
+	if err != nil {
+		logger().Error("Erro ao construir a árvore", "erro", err)
+		return fmt.Errorf("erro ao construir a árvore: %w", err)
+	}
+
+	conflicts := detectConflicts(CompareTrees(&vCurrent.Tree, localTree), tx)
+	for rel, local := range conflicts {
+		for _, p := range local {
+			logger().Warn("Arquivo alterado localmente e no servidor", "caminho", p, "remoto", rel)
+		}
+	}
+	err = resolveConflicts(tx, conflicts, r.opts.Conflict)
+	if err != nil {
+		return err
+	}
+
+	// Último ponto em que o cancelamento não exige desfazer alterações
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	logger().Info("Aplicando arquivos recebidos")
+	err = tx.apply()
+	if err != nil {
+		logger().Error("Erro ao aplicar os arquivos", "erro", err)
+		return fmt.Errorf("erro ao aplicar os arquivos: %w", err)
+	}
+	removeEmptyDirs(r.path, tx.removed)
+
+	logger().Info("Árvore de versionamento atualizada, gerando árvore local")
+	tree, err := r.buildTree(ctx, t.progress)
+	if err != nil {
+		logger().Error("Erro ao construir a árvore", "erro", err)
+		return rollbackPull(tx, fmt.Errorf("erro ao construir a árvore: %w", err))
+	}
+	if tree == nil {
+		logger().Info("A árvore está vazia")
+		return nil
+	}
+	// Com a política ours, os arquivos aplicados podem não alterar nada
+	if CompareHashes(tree.Hash, r.v.Head) {
+		logger().Info("Nenhuma alteração aplicada")
+		return ErrUpToDate
+	}
+
+	logger().Info("Salvando árvore de versionamento")
+	_, err = r.save(ctx, tree, "Pull de "+server)
+	if err != nil {
+		logger().Error("Erro ao salvar a árvore", "erro", err)
+		return rollbackPull(tx, fmt.Errorf("erro ao salvar a árvore: %w", err))
+	}
+	return nil
+}
+
+// Push envia ao servidor as alterações salvas. O envio é interrompido se ctx
+// for cancelado e pode ser retomado depois. Retorna ErrUpToDate se não houver
+// nada a enviar.
+func (r *Repository) Push(ctx context.Context, server string, parameters map[string]string) error {
+	logger().Info("Enviando HEAD para o servidor")
+	vCurrent := r.v
+
+	t := newTransfer(ctx, r.opts.Transfer)
+	err := sendHeadOfVersion(vCurrent.Head, server, parameters, t)
+	if err != nil {
+		return err
+	}
+
+	logger().Info("Repositório atualizado, enviando árvore de versionamento")
+	c, err := sendTreeOfVersion(&vCurrent.Tree, server, parameters, t)
+
+	if err != nil {
+		logger().Error("Erro ao enviar a árvore", "erro", err)
+		return fmt.Errorf("erro ao enviar a árvore: %w", err)
+	}
+
+	if c == nil {
+		return ErrUpToDate
+	}
+
+	err = sendFilesToServer(*c, r.path, server, parameters, t)
+
+	if err != nil {
+		logger().Error("Erro ao enviar os arquivos", "erro", err)
+		return fmt.Errorf("erro ao enviar os arquivos: %w", err)
+	}
+
+	logger().Info("Arquivos enviados com sucesso")
+	return nil
+}
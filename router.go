@@ -0,0 +1,212 @@
+package tinygit
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+)
+
+// Server expõe por HTTP o repositório em RootPath, registrando todos os
+// handlers e limitando a banda de saída. Os limites devem ser definidos antes
+// da primeira requisição.
+type Server struct {
+	RootPath string
+	// Limite de saída, em bytes por segundo, somado entre todas as conexões.
+	// Zero não limita.
+	RateLimit int64
+	// Limite de saída, em bytes por segundo, de cada conexão. Zero não limita.
+	ConnRateLimit int64
+
+	once    sync.Once
+	mux     *http.ServeMux
+	limiter *rateLimiter
+
+	connMu sync.Mutex
+	conns  map[string]*connLimiter
+}
+
+// Limitador de uma conexão, compartilhado pelas requisições feitas nela
+type connLimiter struct {
+	limiter *rateLimiter
+	refs    int
+}
+
+func NewServer(rootPath string) *Server {
+	return &Server{RootPath: rootPath}
+}
+
+func (s *Server) init() {
+	s.limiter = newRateLimiter(s.RateLimit)
+	s.conns = map[string]*connLimiter{}
+	pruneWorktrees(s.RootPath)
+
+	s.mux = http.NewServeMux()
+	s.mux.HandleFunc("/head", s.withRef(CompareHeadsHandler))
+	s.mux.HandleFunc("/tree", s.withTree(func(w http.ResponseWriter, r *http.Request, rootPath string, n Node) {
+		CompareTreesHandler(w, r, n)
+	}))
+	s.mux.HandleFunc("/pull", s.withTree(PullHandler))
+	s.mux.HandleFunc("/pull/manifest", s.withTree(PullManifestHandler))
+	s.mux.HandleFunc("/pull/archive", s.withTree(PullArchiveHandler))
+	s.mux.HandleFunc("/objects/", s.withTree(ObjectHandler))
+	s.mux.HandleFunc("/delta/", s.withTree(DeltaHandler))
+	s.mux.HandleFunc("/push", s.withRoot(PushFilesHandler))
+	s.mux.HandleFunc("/push/signatures", s.withRoot(PushSignaturesHandler))
+	s.mux.HandleFunc("/upload", s.withRoot(CreateUploadHandler))
+	s.mux.HandleFunc("/upload/chunk", s.withRoot(UploadChunkHandler))
+	s.mux.HandleFunc("/upload/status", s.withRoot(UploadStatusHandler))
+	s.mux.HandleFunc("/upload/finalize", s.withRoot(FinalizeUploadHandler))
+	s.mux.HandleFunc("/clone", s.withRef(CloneHandler))
+	s.mux.HandleFunc("/clone/archive", s.withRef(CloneArchiveHandler))
+	s.mux.HandleFunc("/archive", s.withRef(ArchiveHandler))
+	s.mux.HandleFunc("/release", s.withRef(SignatureHandler))
+}
+
+// Os envios alteram o diretório do servidor, por isso só são aceitos sem
+// ref ou com o branch atual do servidor
+func (s *Server) withRoot(h func(http.ResponseWriter, *http.Request, string)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if ref := r.URL.Query().Get(RefParam); ref != "" {
+			v, err := decompressVersionFile(s.RootPath)
+			if err != nil {
+				http.Error(w, "Erro ao ler a árvore salva", http.StatusInternalServerError)
+				return
+			}
+			if ref != v.Branch {
+				http.Error(w, "Envios são aceitos apenas no branch atual do servidor", http.StatusConflict)
+				return
+			}
+		}
+		h(w, r, s.RootPath)
+	}
+}
+
+// Usa o diretório do branch ou da tag do parâmetro ref
+func (s *Server) withRef(h func(http.ResponseWriter, *http.Request, string)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rootPath, ok := s.refPath(w, r)
+		if ok {
+			h(w, r, rootPath)
+		}
+	}
+}
+
+// Lê a árvore salva a cada requisição, para refletir o último commit
+func (s *Server) withTree(h func(http.ResponseWriter, *http.Request, string, Node)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rootPath, ok := s.refPath(w, r)
+		if !ok {
+			return
+		}
+		n, err := GetTreeControlVersion(rootPath)
+		if err != nil {
+			http.Error(w, "Erro ao ler a árvore salva", http.StatusInternalServerError)
+			return
+		}
+		h(w, r, rootPath, *n)
+	}
+}
+
+// Retorna o diretório do branch ou da tag pedido, respondendo com o erro se
+// não for possível
+func (s *Server) refPath(w http.ResponseWriter, r *http.Request) (string, bool) {
+	rootPath, err := refWorktree(s.RootPath, r.URL.Query().Get(RefParam))
+	if errors.Is(err, ErrNotFound) {
+		http.Error(w, "Branch ou tag não encontrado", http.StatusNotFound)
+		return "", false
+	}
+	if errors.Is(err, ErrNoHistory) {
+		http.Error(w, "O commit do branch ou da tag foi salvo sem histórico (--history) e só o commit atual do servidor pode ser servido", http.StatusNotFound)
+		return "", false
+	}
+	if err != nil {
+		logger().Error("Erro ao extrair o branch ou a tag", "ref", r.URL.Query().Get(RefParam), "erro", err)
+		http.Error(w, "Erro ao ler o branch ou a tag", http.StatusInternalServerError)
+		return "", false
+	}
+	return rootPath, true
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.once.Do(s.init)
+
+	var limiters []*rateLimiter
+	if s.limiter != nil {
+		limiters = append(limiters, s.limiter)
+	}
+	if l := s.acquireConn(r.RemoteAddr); l != nil {
+		defer s.releaseConn(r.RemoteAddr)
+		limiters = append(limiters, l)
+	}
+	if len(limiters) > 0 {
+		w = &throttledResponseWriter{ResponseWriter: w, ctx: r.Context(), limiters: limiters}
+	}
+
+	s.mux.ServeHTTP(w, r)
+}
+
+// Retorna o limitador da conexão identificada pelo endereço remoto. Cada
+// conexão TCP tem um endereço e porta próprios.
+func (s *Server) acquireConn(addr string) *rateLimiter {
+	if s.ConnRateLimit <= 0 {
+		return nil
+	}
+
+	s.connMu.Lock()
+	defer s.connMu.Unlock()
+
+	c, found := s.conns[addr]
+	if !found {
+		c = &connLimiter{limiter: newRateLimiter(s.ConnRateLimit)}
+		s.conns[addr] = c
+	}
+	c.refs++
+	return c.limiter
+}
+
+func (s *Server) releaseConn(addr string) {
+	s.connMu.Lock()
+	defer s.connMu.Unlock()
+
+	c := s.conns[addr]
+	c.refs--
+	if c.refs == 0 {
+		delete(s.conns, addr)
+	}
+}
+
+// ResponseWriter que respeita os limites de banda informados
+type throttledResponseWriter struct {
+	http.ResponseWriter
+	// Contexto da requisição: a espera termina se o cliente desconectar
+	ctx      context.Context
+	limiters []*rateLimiter
+}
+
+func (tw *throttledResponseWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > limitedChunkSize {
+			chunk = chunk[:limitedChunkSize]
+		}
+		for _, l := range tw.limiters {
+			if err := l.wait(tw.ctx, len(chunk)); err != nil {
+				return written, err
+			}
+		}
+
+		n, err := tw.ResponseWriter.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[len(chunk):]
+	}
+	return written, nil
+}
+
+func (tw *throttledResponseWriter) Unwrap() http.ResponseWriter {
+	return tw.ResponseWriter
+}
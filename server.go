@@ -2,13 +2,11 @@ package tinygit
 
 import (
 	"archive/zip"
-	"bytes"
 	"encoding/json"
-	"fmt"
+	"errors"
 	"io"
 	"net/http"
 	"os"
-	"path/filepath"
 	"strings"
 	"time"
 )
@@ -16,7 +14,7 @@ import (
 func CompareHeadsHandler(w http.ResponseWriter, r *http.Request, path string) {
 	rHead := r.URL.Query().Get("head")
 
-	fmt.Println("RHEAD:", rHead)
+	logger().Debug("HEAD recebido", "head", rHead)
 	if rHead == "" {
 		w.WriteHeader(http.StatusBadRequest)
 		return
@@ -28,7 +26,7 @@ func CompareHeadsHandler(w http.ResponseWriter, r *http.Request, path string) {
 		return
 	}
 
-	fmt.Println("HEAD:", tree.Hash)
+	logger().Debug("HEAD atual", "head", tree.Hash)
 
 	hasNoChanges := CompareHashes(tree.Hash, rHead)
 
@@ -39,7 +37,15 @@ func CompareHeadsHandler(w http.ResponseWriter, r *http.Request, path string) {
 	}
 }
 
+// CompareTreesHandler retorna as alterações entre a árvore salva e a árvore
+// enviada no corpo da requisição. Com GET, retorna a própria árvore salva.
 func CompareTreesHandler(w http.ResponseWriter, r *http.Request, n Node) {
+	if r.Method == http.MethodGet {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(n)
+		return
+	}
+
 	//Ler Arvore do corpo da requisição
 	rawTree, err := io.ReadAll(r.Body)
 	if err != nil {
@@ -56,6 +62,9 @@ func CompareTreesHandler(w http.ResponseWriter, r *http.Request, n Node) {
 
 	//Comparar arvores
 	c := CompareTrees(&n, &tree)
+	if !acceptsRenames(r) {
+		c.foldRenames()
+	}
 
 	//Retornar alterações
 	b, err := json.Marshal(c)
@@ -71,15 +80,11 @@ func CompareTreesHandler(w http.ResponseWriter, r *http.Request, n Node) {
 
 func PullHandler(w http.ResponseWriter, r *http.Request, rootPath string, n Node) {
 
-	fmt.Println("n.Path:", n.Path)
-	fmt.Println("n.Hash:", n.Hash)
-	fmt.Println("n.Type:", n.Type)
-
 	ctx := r.Context()
 	//Ler Arvore do corpo da requisição
 	rawTree, err := io.ReadAll(r.Body)
 	if err != nil {
-		fmt.Println("ERRO AO LER O CORPO DA REQUISIÇÃO:", err)
+		logger().Error("Erro ao ler o corpo da requisição", "erro", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
@@ -87,105 +92,58 @@ func PullHandler(w http.ResponseWriter, r *http.Request, rootPath string, n Node
 	var tree Node
 	err = json.Unmarshal(rawTree, &tree)
 	if err != nil {
-		fmt.Println("ERRO AO DECODIFICAR JSON:", err)
+		logger().Error("Erro ao decodificar JSON", "erro", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
-	//Comparar arvores
+	//Comparar arvores. O zip contém todos os arquivos, inclusive os renomeados
 	c := CompareTrees(&tree, &n)
-	for _, node := range c.Removed {
-		fmt.Println("REMOVED NODE PATH:", node.Path)
+	c.foldRenames()
+	logChanges(c)
+
+	m, err := newManifest(rootPath, n.Hash, c)
+	if err != nil {
+		logger().Error("Erro ao gerar manifesto", "erro", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
 	}
-	for _, node := range c.Modified {
-		fmt.Println("MODIFIED NODE PATH:", node.Path)
+
+	release, err := readSignedRelease(rootPath)
+	if err != nil {
+		logger().Error("Erro ao ler a versão assinada", "erro", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
 	}
-	for _, node := range c.Added {
-		fmt.Println("ADDED NODE PATH:", node.Path)
+
+	if ctx.Err() != nil {
+		logger().Debug("Requisição cancelada")
+		return
 	}
 
 	pr, pw := io.Pipe()
 	zipWriter := zip.NewWriter(pw)
-	removed := []string{}
-	for _, node := range c.Removed {
-		fmt.Println("REMOVED NODE PATH:", node.Path)
-		removed = append(removed, node.Path)
-	}
 
-	select {
-	case <-ctx.Done():
-		// Operação foi cancelada
-		fmt.Println("REQUISIÇÃO CANCELADA")
-		http.Error(w, "Request canceled", http.StatusRequestTimeout)
-		return
-	default:
-
-		go func() {
-			defer pw.Close()
-			defer zipWriter.Close()
-
-			fmt.Println("n PATH:", n.Path)
-			fmt.Println("ROOT PATH:", rootPath)
-
-			err = filepath.Walk(rootPath, func(path string, info os.FileInfo, err error) error {
-				if err != nil {
-					return err
-				}
-
-				if info.IsDir() {
-					return nil
-				}
-
-				relPath := strings.TrimPrefix(path, rootPath)
-				relPath = strings.TrimPrefix(relPath, string(filepath.Separator))
-
-				for _, node := range c.Modified {
-					if node.Path != relPath {
-						continue
-					}
-
-					fmt.Println("ARQUIVO MODIFICADO:", relPath)
-					err = addFileToZip(zipWriter, path, relPath, info)
-					if err != nil {
-						return err
-					}
-				}
-
-				for _, node := range c.Added {
-					logFile, err := os.OpenFile(filepath.Join(rootPath, "log.txt"), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-					if err != nil {
-						return err
-					}
-					defer logFile.Close()
-					logFile.WriteString(fmt.Sprintf("path: %s", path))
-					logFile.WriteString(fmt.Sprintf("node path: %s\n", node.Path))
-					if node.Path != relPath {
-						continue
-					}
-
-					fmt.Println("ARQUIVO ADICIONADO", relPath)
-					err = addFileToZip(zipWriter, path, relPath, info)
-					if err != nil {
-						return err
-					}
-				}
-
-				return nil
-			})
-			if err != nil {
-				fmt.Println("ERRO AO PERCORRER DIRETÓRIO:", err)
-			}
-
-		}()
-
-		w.Header().Set("Content-Type", "application/zip")
-		w.Header().Set("Content-Disposition", "attachment; filename=pull.zip")
-		w.Header().Set("Removed", strings.Join(removed, ","))
-		w.WriteHeader(http.StatusOK)
-		io.Copy(w, pr)
-	}
+	go func() {
+		err := writeArchive(ctx, zipWriter, rootPath, m, release)
+		if err != nil {
+			logger().Error("Erro ao compactar arquivos", "erro", err)
+		}
+		pw.CloseWithError(err)
+	}()
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", "attachment; filename=pull.zip")
+	w.WriteHeader(http.StatusOK)
+	_, err = io.Copy(w, pr)
+
+	// Se o cliente desconectou, faz a goroutine parar de compactar
+	pr.CloseWithError(err)
 }
 
+// Tamanho máximo, em bytes, do zip aceito por PushFilesHandler
+var MaxPushSize int64 = 4 << 30
+
 // PushFilesHandler Recebe arquivos e atualiza a arvore
 func PushFilesHandler(w http.ResponseWriter, r *http.Request, rootPath string) {
 	// Verifica se o método é POST
@@ -193,17 +151,9 @@ func PushFilesHandler(w http.ResponseWriter, r *http.Request, rootPath string) {
 		http.Error(w, "Método não permitido", http.StatusMethodNotAllowed)
 		return
 	}
-
-	// Lê o arquivo zip do corpo da requisição
-	buf := new(bytes.Buffer)
-	_, err := io.Copy(buf, r.Body)
-	if err != nil {
-		http.Error(w, "Erro ao ler o corpo da requisição", http.StatusInternalServerError)
-		return
-	}
 	defer r.Body.Close()
 
-	// Salva o arquivo zip recebido em disco
+	// Salva o arquivo zip recebido em disco, sem mantê-lo em memória
 	tempZipFile, err := os.CreateTemp("", "tinygit-"+time.Now().Format("20060102150405")+".zip")
 	if err != nil {
 		http.Error(w, "Erro ao criar arquivo temporário", http.StatusInternalServerError)
@@ -211,17 +161,29 @@ func PushFilesHandler(w http.ResponseWriter, r *http.Request, rootPath string) {
 	}
 	defer os.Remove(tempZipFile.Name())
 
-	_, err = tempZipFile.Write(buf.Bytes())
+	_, err = io.Copy(tempZipFile, http.MaxBytesReader(w, r.Body, MaxPushSize))
+	if err != nil {
+		tempZipFile.Close()
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			http.Error(w, "Arquivo excede o tamanho máximo permitido", http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, "Erro ao salvar arquivo temporário", http.StatusInternalServerError)
+		return
+	}
+
+	err = tempZipFile.Close()
 	if err != nil {
 		http.Error(w, "Erro ao salvar arquivo temporário", http.StatusInternalServerError)
 		return
 	}
 
 	// Descompacta o arquivo zip
-	err = unzipFiles(tempZipFile.Name(), rootPath)
+	err = unzipPushFiles(tempZipFile.Name(), rootPath)
 
 	if err != nil {
-		http.Error(w, "Erro ao descompactar arquivos", http.StatusInternalServerError)
+		writePushError(w, err)
 		return
 	}
 
@@ -248,53 +210,35 @@ func CloneHandler(w http.ResponseWriter, r *http.Request, rootPath string) {
 		return
 	}
 
-	select {
-	case <-ctx.Done():
+	m, err := cloneManifest(rootPath, v)
+	if err != nil {
+		http.Error(w, "Erro ao percorrer diretório", http.StatusInternalServerError)
 		return
-	default:
-		pr, pw := io.Pipe()
-		zipWriter := zip.NewWriter(pw)
-
-		go func() {
-			defer pw.Close()
-			defer zipWriter.Close()
-
-			err = filepath.Walk(rootPath, func(path string, info os.FileInfo, err error) error {
-				if err != nil {
-					return err
-				}
-
-				if info.IsDir() {
-					return nil
-				}
-
-				ext := filepath.Ext(path)
-				if !contains(v.ExtensionsToGenerateVersion, ext) {
-					return nil
-				}
+	}
 
-				relPath := strings.TrimPrefix(path, rootPath)
-				relPath = strings.TrimPrefix(relPath, string(filepath.Separator))
+	release, err := readSignedRelease(rootPath)
+	if err != nil {
+		http.Error(w, "Erro ao ler a versão assinada", http.StatusInternalServerError)
+		return
+	}
 
-				err = addFileToZip(zipWriter, path, relPath, info)
-				if err != nil {
+	if ctx.Err() != nil {
+		return
+	}
 
-					return err
-				}
-				return nil
-			})
+	pr, pw := io.Pipe()
+	zipWriter := zip.NewWriter(pw)
 
-			if err != nil {
-				http.Error(w, "Erro ao percorrer diretório", http.StatusInternalServerError)
-				return
+	go func() {
+		pw.CloseWithError(writeArchive(ctx, zipWriter, rootPath, m, release))
+	}()
 
-			}
-		}()
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", "attachment; filename=clone.zip")
+	w.Header().Set("Config-Ext", strings.Join(v.ExtensionsToGenerateVersion, ","))
+	w.WriteHeader(http.StatusOK)
+	_, err = io.Copy(w, pr)
 
-		w.Header().Set("Content-Type", "application/zip")
-		w.Header().Set("Content-Disposition", "attachment; filename=clone.zip")
-		w.Header().Set("Config-Ext", strings.Join(v.ExtensionsToGenerateVersion, ","))
-		w.WriteHeader(http.StatusOK)
-		io.Copy(w, pr)
-	}
+	// Se o cliente desconectou, faz a goroutine parar de compactar
+	pr.CloseWithError(err)
 }
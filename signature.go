@@ -0,0 +1,398 @@
+package tinygit
+
+import (
+	"archive/zip"
+	"bufio"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	releaseFileName    = "release"
+	trustedKeysName    = "trusted_keys"
+	releaseEntryName   = ".tinygit-release.json"
+	signatureAlgorithm = "ed25519"
+)
+
+// Release descreve o conteúdo de uma versão assinada: o HEAD e todos os
+// arquivos versionados com seus hashes de conteúdo
+type Release struct {
+	Head  string          `json:"head"`
+	Files []ManifestEntry `json:"files"`
+}
+
+// SignedRelease guarda o JSON da Release exatamente como foi assinado
+type SignedRelease struct {
+	Algorithm string `json:"algorithm"`
+	Release   []byte `json:"release"`
+	Signature []byte `json:"signature"`
+}
+
+// GenerateSigningKey gera um par de chaves Ed25519, gravando a chave privada
+// em privPath e a pública em privPath + ".pub"
+func GenerateSigningKey(privPath string) error {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return err
+	}
+
+	err = os.WriteFile(privPath, []byte(base64.StdEncoding.EncodeToString(priv.Seed())+"\n"), 0600)
+	if err != nil {
+		return fmt.Errorf("erro ao gravar a chave privada: %v", err)
+	}
+
+	err = os.WriteFile(privPath+".pub", []byte(base64.StdEncoding.EncodeToString(pub)+"\n"), 0644)
+	if err != nil {
+		return fmt.Errorf("erro ao gravar a chave pública: %v", err)
+	}
+
+	return nil
+}
+
+// SignRelease assina a árvore salva do repositório com a chave privada
+// informada. A assinatura é gravada em .tinygit/release e enviada pelo
+// servidor junto com as atualizações.
+func SignRelease(path, keyFile string) error {
+	if !VerifyIfExistVersionControl(path) {
+		return ErrNotInitialized
+	}
+
+	raw, err := readKeyFile(keyFile)
+	if err != nil {
+		return err
+	}
+	if len(raw) != ed25519.SeedSize {
+		return fmt.Errorf("chave privada inválida")
+	}
+	priv := ed25519.NewKeyFromSeed(raw)
+
+	v, err := decompressVersionFile(path)
+	if err != nil {
+		logger().Error("Erro ao ler a árvore salva", "erro", err)
+		return err
+	}
+
+	release := Release{Head: v.Head, Files: []ManifestEntry{}}
+	if v.Tree.Hash != "" {
+		release.Files, err = releaseFiles(&v.Tree)
+		if err != nil {
+			return fmt.Errorf("erro ao ler os arquivos da versão: %v", err)
+		}
+	}
+
+	b, err := json.Marshal(release)
+	if err != nil {
+		return err
+	}
+
+	sr := SignedRelease{
+		Algorithm: signatureAlgorithm,
+		Release:   b,
+		Signature: ed25519.Sign(priv, b),
+	}
+
+	sb, err := json.Marshal(sr)
+	if err != nil {
+		return err
+	}
+
+	logger().Info("Versão assinada", "head", v.Head, "arquivos", len(release.Files))
+	return os.WriteFile(filepath.Join(path, versionDirName, releaseFileName), sb, 0644)
+}
+
+// Verifica se a versão assinada é a da árvore com o hash head. Versões
+// assinadas antes de os nomes entrarem no hash dos diretórios têm um HEAD
+// calculado de outra forma; nelas, o hash é recalculado a partir dos arquivos
+// assinados.
+func (release *Release) matchesHead(head string) bool {
+	if CompareHashes(release.Head, head) {
+		return true
+	}
+	files := map[string]*Node{}
+	for _, entry := range release.Files {
+		files[entry.Path] = &Node{Path: entry.Path, Hash: entry.Hash, Type: blobType, Size: entry.Size, ContentHash: entry.ContentHash}
+	}
+	tree := treeFromBlobs(files)
+	return tree != nil && CompareHashes(tree.Hash, head)
+}
+
+// Lista os arquivos da árvore salva com os hashes de conteúdo calculados no
+// commit. O diretório de trabalho não é lido, para que alterações não salvas
+// não entrem na versão assinada.
+func releaseFiles(tree *Node) ([]ManifestEntry, error) {
+	files := []ManifestEntry{}
+	for _, node := range sortedBlobs([]*Node{tree}) {
+		if node.ContentHash == "" {
+			return nil, fmt.Errorf("%s sem hash de conteúdo na árvore salva, faça um novo commit", node.Path)
+		}
+		files = append(files, ManifestEntry{
+			Path:        node.Path,
+			Hash:        node.Hash,
+			ContentHash: node.ContentHash,
+			Type:        blobType,
+			Size:        node.Size,
+		})
+	}
+	return files, nil
+}
+
+// TrustKey adiciona uma chave pública à lista de chaves confiáveis do
+// diretório. Com ao menos uma chave configurada, pull e clone recusam
+// atualizações sem assinatura válida.
+func TrustKey(path, pubKeyFile string) error {
+	raw, err := readKeyFile(pubKeyFile)
+	if err != nil {
+		return err
+	}
+	if len(raw) != ed25519.PublicKeySize {
+		return fmt.Errorf("chave pública inválida")
+	}
+
+	err = generateVersionDir(path)
+	if err != nil {
+		return err
+	}
+
+	keys, err := loadTrustedKeys(path)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if key.Equal(ed25519.PublicKey(raw)) {
+			return nil
+		}
+	}
+
+	f, err := os.OpenFile(filepath.Join(path, versionDirName, trustedKeysName), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = f.WriteString(base64.StdEncoding.EncodeToString(raw) + "\n")
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func readKeyFile(keyFile string) ([]byte, error) {
+	b, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao ler a chave: %v", err)
+	}
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(b)))
+	if err != nil {
+		return nil, fmt.Errorf("erro ao decodificar a chave: %v", err)
+	}
+	return raw, nil
+}
+
+// Lê as chaves públicas confiáveis. Linhas vazias e iniciadas por # são ignoradas.
+func loadTrustedKeys(path string) ([]ed25519.PublicKey, error) {
+	f, err := os.Open(filepath.Join(path, versionDirName, trustedKeysName))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var keys []ed25519.PublicKey
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		raw, err := base64.StdEncoding.DecodeString(line)
+		if err != nil || len(raw) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("chave confiável inválida: %s", line)
+		}
+		keys = append(keys, ed25519.PublicKey(raw))
+	}
+
+	return keys, scanner.Err()
+}
+
+// Verifica a assinatura com as chaves confiáveis e retorna a Release assinada
+func verifySignedRelease(sr *SignedRelease, keys []ed25519.PublicKey) (*Release, error) {
+	if sr == nil || len(sr.Signature) == 0 {
+		return nil, fmt.Errorf("%w: atualização não assinada", ErrInvalidSignature)
+	}
+	if sr.Algorithm != signatureAlgorithm {
+		return nil, fmt.Errorf("%w: algoritmo não suportado: %s", ErrInvalidSignature, sr.Algorithm)
+	}
+
+	valid := false
+	for _, key := range keys {
+		if ed25519.Verify(key, sr.Release, sr.Signature) {
+			valid = true
+			break
+		}
+	}
+	if !valid {
+		return nil, fmt.Errorf("%w ou de chave não confiável", ErrInvalidSignature)
+	}
+
+	var release Release
+	err := json.Unmarshal(sr.Release, &release)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao decodificar a versão assinada: %v", err)
+	}
+	return &release, nil
+}
+
+// Se o diretório possui chaves confiáveis, exige que a transação traga uma
+// versão assinada do mesmo HEAD informado no manifesto e que o resultado
+// seja essa versão: os arquivos preparados e removidos devem estar de acordo
+// com ela e os arquivos assinados que não foram recebidos devem existir em
+// local, a árvore salva do cliente (nil em um clone), com o mesmo conteúdo
+func (tx *pullTransaction) verifySignature(local *Node) error {
+	keys, err := loadTrustedKeys(tx.rootPath)
+	if err != nil {
+		return err
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+
+	release, err := verifySignedRelease(tx.release, keys)
+	if err != nil {
+		return err
+	}
+
+	if tx.head == "" || !release.matchesHead(tx.head) {
+		return fmt.Errorf("%w: versão assinada de outro HEAD (%s)", ErrInvalidSignature, release.Head)
+	}
+
+	signed := map[string]ManifestEntry{}
+	for _, entry := range release.Files {
+		signed[entry.Path] = entry
+	}
+
+	for _, rel := range tx.files {
+		entry, found := signed[rel]
+		if !found {
+			return fmt.Errorf("%s: arquivo não consta na versão assinada", rel)
+		}
+		contentHash, err := calculateContentHash(filepath.Join(tx.stagingDir, rel))
+		if err != nil {
+			return err
+		}
+		if !CompareHashes(contentHash, entry.ContentHash) {
+			return fmt.Errorf("%s: conteúdo diferente da versão assinada", rel)
+		}
+	}
+
+	for _, rel := range tx.removed {
+		for path := range signed {
+			if isUnder(path, rel) {
+				return fmt.Errorf("%s: remoção de arquivo da versão assinada", rel)
+			}
+		}
+	}
+
+	// Os arquivos que não foram recebidos devem continuar como estão
+	staged := map[string]bool{}
+	for _, rel := range tx.files {
+		staged[rel] = true
+	}
+	localFiles := map[string]*Node{}
+	collectBlobs(local, localFiles)
+	for path, entry := range signed {
+		if staged[path] {
+			continue
+		}
+		node, found := localFiles[path]
+		if !found {
+			return fmt.Errorf("%s: arquivo da versão assinada não recebido", path)
+		}
+		contentHash := node.ContentHash
+		if contentHash == "" {
+			contentHash, err = calculateContentHash(filepath.Join(tx.rootPath, path))
+			if err != nil {
+				return err
+			}
+		}
+		if !CompareHashes(contentHash, entry.ContentHash) {
+			return fmt.Errorf("%s: conteúdo diferente da versão assinada", path)
+		}
+	}
+
+	logger().Info("Assinatura da versão verificada", "head", release.Head)
+	return nil
+}
+
+// Lê a versão assinada do servidor, se existir
+func readSignedRelease(rootPath string) ([]byte, error) {
+	b, err := os.ReadFile(filepath.Join(rootPath, versionDirName, releaseFileName))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return b, err
+}
+
+// Escreve a versão assinada no zip enviado ao cliente
+func writeReleaseToZip(zipWriter *zip.Writer, release []byte) error {
+	writer, err := zipWriter.Create(releaseEntryName)
+	if err != nil {
+		return err
+	}
+	_, err = writer.Write(release)
+	return err
+}
+
+// Lê a versão assinada de um zip recebido. Retorna nil se não houver.
+func readReleaseFromZip(r *zip.Reader) (*SignedRelease, error) {
+	for _, f := range r.File {
+		if f.Name != releaseEntryName {
+			continue
+		}
+
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer rc.Close()
+
+		raw, err := io.ReadAll(rc)
+		if err != nil {
+			return nil, err
+		}
+
+		var sr SignedRelease
+		err = json.Unmarshal(raw, &sr)
+		if err != nil {
+			return nil, fmt.Errorf("erro ao decodificar a versão assinada: %v", err)
+		}
+		return &sr, nil
+	}
+
+	return nil, nil
+}
+
+// SignatureHandler envia a versão assinada do repositório
+func SignatureHandler(w http.ResponseWriter, r *http.Request, rootPath string) {
+	release, err := readSignedRelease(rootPath)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if release == nil {
+		http.Error(w, "Versão não assinada", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(release)
+}
@@ -0,0 +1,146 @@
+package tinygit
+
+import (
+	"context"
+	"sort"
+)
+
+// FileState é a situação de um arquivo em relação à árvore salva
+type FileState string
+
+const (
+	FileAdded    FileState = "added"
+	FileModified FileState = "modified"
+	FileRemoved  FileState = "removed"
+)
+
+// FileStatus descreve um arquivo alterado. Os campos Old* vêm da árvore
+// salva e os New* do diretório de trabalho; ficam vazios quando o arquivo não
+// existe no lado correspondente.
+type FileStatus struct {
+	Path    string    `json:"path"`
+	State   FileState `json:"state"`
+	OldHash string    `json:"oldHash,omitempty"`
+	NewHash string    `json:"newHash,omitempty"`
+	OldSize int64     `json:"oldSize"`
+	NewSize int64     `json:"newSize"`
+}
+
+// Status é o resultado da comparação do diretório de trabalho com a árvore
+// salva
+type Status struct {
+	// Hash da árvore salva
+	Head string `json:"head"`
+	// Branch atual, vazio se o commit atual não estiver em um branch
+	Branch string `json:"branch,omitempty"`
+	// Arquivos preparados para o commit, comparados com a árvore salva e
+	// ordenados pelo caminho
+	Staged []FileStatus `json:"staged"`
+	// Arquivos alterados e não preparados, comparados com o índice (ou com a
+	// árvore salva, se nada estiver preparado) e ordenados pelo caminho
+	Files []FileStatus `json:"files"`
+	// Arquivos cuja extensão não é versionada
+	Untracked int `json:"untracked"`
+	// Arquivos e diretórios ignorados pelo nome, sem contar o conteúdo dos
+	// diretórios
+	Ignored int `json:"ignored"`
+	// Verdadeiro se não houver arquivos alterados nem preparados
+	Clean bool `json:"clean"`
+}
+
+// Status compara a árvore salva com o índice e o índice com o diretório de
+// trabalho. O percurso e o cálculo dos hashes são interrompidos se ctx for
+// cancelado.
+func (r *Repository) Status(ctx context.Context) (*Status, error) {
+	s, _, err := r.status(ctx)
+	return s, err
+}
+
+// Retorna o status e a árvore atual do diretório de trabalho
+func (r *Repository) status(ctx context.Context) (*Status, *Node, error) {
+	logger().Info("Verificando status de controle de versão", "caminho", r.path)
+
+	b := &treeBuilder{ctx: ctx, rootPath: r.path, ext: &r.v.ExtensionsToGenerateVersion, ignore: &r.v.ignoredFiles}
+	currentTree, err := b.buildRoot()
+	if err != nil {
+		logger().Error("Erro ao construir a árvore", "erro", err)
+		return nil, nil, err
+	}
+
+	logChanges(CompareTrees(&r.v.Tree, currentTree))
+
+	s := &Status{
+		Head:      r.v.Head,
+		Branch:    r.v.Branch,
+		Staged:    []FileStatus{},
+		Files:     compareFiles(r.stagedTree(), currentTree),
+		Untracked: b.untracked,
+		Ignored:   b.ignored,
+	}
+	if r.index != nil {
+		s.Staged = compareFiles(&r.v.Tree, &r.index.Tree)
+	}
+	s.Clean = len(s.Files) == 0 && len(s.Staged) == 0
+	if s.Clean {
+		logger().Debug("Nenhuma mudança detectada")
+	}
+
+	return s, currentTree, nil
+}
+
+// Compara os arquivos das duas árvores, retornando os alterados ordenados
+// pelo caminho
+func compareFiles(saved, current *Node) []FileStatus {
+	return compareBlobs(saved, current, func(a, b *Node) bool {
+		return CompareHashes(a.Hash, b.Hash)
+	})
+}
+
+// Compara os arquivos das duas árvores, considerando iguais os que same
+// aceitar
+func compareBlobs(saved, current *Node, same func(old, node *Node) bool) []FileStatus {
+	savedFiles := map[string]*Node{}
+	collectBlobs(saved, savedFiles)
+	currentFiles := map[string]*Node{}
+	collectBlobs(current, currentFiles)
+
+	files := []FileStatus{}
+	for path, node := range currentFiles {
+		old, found := savedFiles[path]
+		switch {
+		case !found:
+			files = append(files, FileStatus{Path: path, State: FileAdded, NewHash: node.Hash, NewSize: node.Size})
+		case !same(old, node):
+			files = append(files, FileStatus{
+				Path:    path,
+				State:   FileModified,
+				OldHash: old.Hash,
+				NewHash: node.Hash,
+				OldSize: old.Size,
+				NewSize: node.Size,
+			})
+		}
+	}
+	for path, old := range savedFiles {
+		if _, found := currentFiles[path]; !found {
+			files = append(files, FileStatus{Path: path, State: FileRemoved, OldHash: old.Hash, OldSize: old.Size})
+		}
+	}
+
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+	return files
+}
+
+// Mapeia os arquivos da árvore pelo caminho
+func collectBlobs(node *Node, files map[string]*Node) {
+	if node == nil || node.Hash == "" {
+		return
+	}
+	if node.Type != treeType {
+		files[node.Path] = node
+		return
+	}
+	for _, child := range node.Children {
+		collectBlobs(child, files)
+	}
+}
@@ -0,0 +1,179 @@
+package tinygit
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// Tamanho máximo dos arquivos comparados linha a linha
+	maxTextDiffSize = 1 << 20
+	// Limite de linhas antigas × novas comparadas após remover o início e o
+	// fim em comum, para limitar a memória usada
+	maxTextDiffCells = 4 << 20
+	// Linhas inalteradas mostradas em volta de cada alteração
+	diffContextLines = 3
+)
+
+// Indica se o conteúdo pode ser comparado como texto
+func isText(content []byte) bool {
+	return len(content) <= maxTextDiffSize && bytes.IndexByte(content, 0) < 0 && utf8.Valid(content)
+}
+
+func splitLines(content []byte) []string {
+	if len(content) == 0 {
+		return nil
+	}
+	lines := strings.SplitAfter(string(content), "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// Operação do diff: ' ' linha mantida, '-' removida e '+' adicionada
+type diffLine struct {
+	op   byte
+	text string
+}
+
+// Calcula as linhas mantidas, removidas e adicionadas pela maior subsequência
+// comum. Retorna false se os arquivos forem grandes demais para comparar.
+func diffLines(a, b []string) ([]diffLine, bool) {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	midA, midB := a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+	if (len(midA)+1)*(len(midB)+1) > maxTextDiffCells {
+		return nil, false
+	}
+
+	// lcs[i][j] é o tamanho da maior subsequência comum de midA[i:] e midB[j:]
+	cols := len(midB) + 1
+	lcs := make([]int32, (len(midA)+1)*cols)
+	for i := len(midA) - 1; i >= 0; i-- {
+		for j := len(midB) - 1; j >= 0; j-- {
+			if midA[i] == midB[j] {
+				lcs[i*cols+j] = lcs[(i+1)*cols+j+1] + 1
+			} else if lcs[(i+1)*cols+j] >= lcs[i*cols+j+1] {
+				lcs[i*cols+j] = lcs[(i+1)*cols+j]
+			} else {
+				lcs[i*cols+j] = lcs[i*cols+j+1]
+			}
+		}
+	}
+
+	lines := make([]diffLine, 0, len(a)+len(b)-prefix-suffix)
+	for _, text := range a[:prefix] {
+		lines = append(lines, diffLine{' ', text})
+	}
+	i, j := 0, 0
+	for i < len(midA) || j < len(midB) {
+		switch {
+		case i < len(midA) && j < len(midB) && midA[i] == midB[j]:
+			lines = append(lines, diffLine{' ', midA[i]})
+			i++
+			j++
+		case j == len(midB) || i < len(midA) && lcs[(i+1)*cols+j] >= lcs[i*cols+j+1]:
+			lines = append(lines, diffLine{'-', midA[i]})
+			i++
+		default:
+			lines = append(lines, diffLine{'+', midB[j]})
+			j++
+		}
+	}
+	for _, text := range a[len(a)-suffix:] {
+		lines = append(lines, diffLine{' ', text})
+	}
+	return lines, true
+}
+
+// Gera o diff unificado entre os conteúdos, com diffContextLines linhas de
+// contexto. Retorna false se os arquivos forem grandes demais para comparar.
+func unifiedDiff(oldName, newName string, oldContent, newContent []byte) (string, bool) {
+	lines, ok := diffLines(splitLines(oldContent), splitLines(newContent))
+	if !ok {
+		return "", false
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "--- %s\n+++ %s\n", oldName, newName)
+
+	// Posição de cada linha nos arquivos antigo e novo
+	oldLine, newLine := make([]int, len(lines)+1), make([]int, len(lines)+1)
+	for k, l := range lines {
+		oldLine[k+1], newLine[k+1] = oldLine[k], newLine[k]
+		if l.op != '+' {
+			oldLine[k+1]++
+		}
+		if l.op != '-' {
+			newLine[k+1]++
+		}
+	}
+
+	for k := 0; k < len(lines); {
+		if lines[k].op == ' ' {
+			k++
+			continue
+		}
+
+		// O trecho se estende enquanto as alterações estiverem a até
+		// 2*diffContextLines linhas umas das outras
+		start := k - diffContextLines
+		if start < 0 {
+			start = 0
+		}
+		end := k
+		for end < len(lines) {
+			if lines[end].op != ' ' {
+				end++
+				continue
+			}
+			next := end
+			for next < len(lines) && lines[next].op == ' ' {
+				next++
+			}
+			if next == len(lines) || next-end > 2*diffContextLines {
+				end += diffContextLines
+				if end > next {
+					end = next
+				}
+				break
+			}
+			end = next
+		}
+
+		fmt.Fprintf(&b, "@@ -%s +%s @@\n",
+			hunkRange(oldLine[start], oldLine[end]-oldLine[start]),
+			hunkRange(newLine[start], newLine[end]-newLine[start]))
+		for _, l := range lines[start:end] {
+			b.WriteByte(l.op)
+			b.WriteString(l.text)
+			if !strings.HasSuffix(l.text, "\n") {
+				b.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+		k = end
+	}
+
+	return b.String(), true
+}
+
+// Intervalo de linhas no formato do diff unificado, numerado a partir de 1
+func hunkRange(start, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	if count == 1 {
+		return fmt.Sprintf("%d", start+1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
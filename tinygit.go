@@ -1,6 +1,8 @@
 package tinygit
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
 )
@@ -11,14 +13,27 @@ type Versioning struct {
 	ignoredFiles                []string
 	Head                        string
 	Tree                        Node
+	// Identificador do commit atual em .tinygit/commits
+	Commit string
+	// Branch atual, avançado a cada commit; vazio se o commit atual não
+	// estiver em um branch
+	Branch string
+	// Guarda o conteúdo dos arquivos de cada commit em .tinygit/objects
+	History bool
+	// Versão do hash dos diretórios com que o arquivo foi gravado. Tree e
+	// Head são atualizados ao ler; o arquivo, na próxima gravação.
+	TreeVersion int
 }
 
 // Structure to represent the tree of files and directories
 type Node struct {
 	Path     string  `json:"path"`
 	Hash     string  `json:"hash"`
-	Type     string  `json:"type"` // "blob" para arquivos, "tree" para diretórios
+	Type     string  `json:"type"`           // "blob" para arquivos, "tree" para diretórios
+	Size     int64   `json:"size,omitempty"` // Nos diretórios, soma dos arquivos
 	Children []*Node `json:"children,omitempty"`
+	// Hash apenas do conteúdo dos arquivos, usado para detectar renomeações
+	ContentHash string `json:"contentHash,omitempty"`
 }
 
 // Structure to represent the changes between two trees
@@ -26,6 +41,9 @@ type Changes struct {
 	Added    []*Node `json:"added"`
 	Removed  []*Node `json:"removed"`
 	Modified []*Node `json:"modified"`
+	// Arquivos removidos e adicionados com o mesmo conteúdo, que não
+	// aparecem em Added e Removed
+	Renamed []Rename `json:"renamed,omitempty"`
 }
 
 const (
@@ -37,173 +55,71 @@ const (
 
 // Function to initialize the version control in the directory
 func InitControlVersion(path string, extPermited, ignoredFiles []string) error {
-	if VerifyIfExistVersionControl(path) {
-		fmt.Println("Controle de versão já inicializado.")
+	_, err := Init(path, Options{Extensions: extPermited, Ignore: ignoredFiles})
+	if errors.Is(err, ErrAlreadyInitialized) {
+		logger().Info("Controle de versão já inicializado")
 		return nil
 	}
-	err := generateVersionDir(path)
-	if err != nil {
-		fmt.Println("Erro ao criar diretório de versão:", err)
-		return err
-	}
-
-	fmt.Println("Controle de versão inicializado em", path)
-	tree, err := buildTree(path, path, &extPermited, &ignoredFiles)
-	if err != nil {
-		fmt.Println("Erro ao construir a árvore:", err)
-		return err
-	}
-	if tree == nil {
-		fmt.Println("A árvore está vazia.")
-		tree = &Node{
-			Hash: "",
-		}
-	}
-
-	v := Versioning{
-		ExtensionsToGenerateVersion: extPermited,
-		ignoredFiles:                ignoredFiles,
-		Head:                        tree.Hash,
-		Tree:                        *tree,
-	}
-
-	err = generateVersionFile(path, &v)
-	if err != nil {
-		fmt.Println("Erro ao salvar a árvore:", err)
-		return err
-	}
-	return nil
+	return err
 }
 
 func CommitControlVersion(path string, ext, ignore []string) error {
-	if ignore == nil {
-		ignore = []string{}
-	}
+	return CommitControlVersionContext(context.Background(), path, ext, ignore)
+}
 
-	c, v, err := StatusControlVersion(path, ext, ignore)
+// CommitControlVersionContext salva a árvore atual. O cálculo dos hashes é
+// interrompido se ctx for cancelado.
+func CommitControlVersionContext(ctx context.Context, path string, ext, ignore []string) error {
+	r, err := OpenWithOptions(path, Options{Extensions: ext, Ignore: ignore})
 	if err != nil {
-		fmt.Println("Erro ao verificar o status:", err)
 		return err
 	}
 
-	if c == nil {
+	_, err = r.Commit(ctx, "")
+	if errors.Is(err, ErrNoChanges) {
 		return nil
 	}
-	if len(c.Modified) == 0 && len(c.Added) == 0 && len(c.Removed) == 0 {
-		return nil
-	}
-
-	v.Tree = *c.Modified[0]
-
-	fmt.Println("Salvando árvore de versionamento...")
-	err = generateVersionFile(path, v)
-	if err != nil {
-		fmt.Println("Erro ao salvar a árvore:", err)
-		return err
-	}
-
-	return nil
+	return err
 }
 
 func StatusControlVersion(path string, ext, ignore []string) (*Changes, *Versioning, error) {
-	fmt.Println("Verificando status de controle de versão em", path)
-	if !VerifyIfExistVersionControl(path) {
-		return nil, nil, fmt.Errorf("controle de versão não inicializado")
-	}
+	return StatusControlVersionContext(context.Background(), path, ext, ignore)
+}
 
-	v, err := decompressVersionFile(path)
+// StatusControlVersionContext compara a árvore salva com o diretório. O
+// percurso e o cálculo dos hashes são interrompidos se ctx for cancelado.
+// Retorna nil se não houver alterações; Repository.Status retorna o
+// resultado completo. Arquivos pequenos renomeados e alterados também são
+// informados em Renamed.
+func StatusControlVersionContext(ctx context.Context, path string, ext, ignore []string) (*Changes, *Versioning, error) {
+	r, err := OpenWithOptions(path, Options{Extensions: ext, Ignore: ignore})
 	if err != nil {
-		fmt.Println("Erro ao ler a árvore salva:", err)
 		return nil, nil, err
 	}
 
-	if ignore != nil {
-		diff := CompareSlices(v.ignoredFiles, ignore)
-		if len(diff) > 0 {
-			v.ignoredFiles = append(v.ignoredFiles, diff...)
-		}
-	}
-
-	if ext != nil {
-		diff := CompareSlices(v.ExtensionsToGenerateVersion, ext)
-		if len(diff) > 0 {
-			v.ExtensionsToGenerateVersion = append(v.ExtensionsToGenerateVersion, diff...)
-		}
-	}
-
-	currentTree, err := buildTree(path, path, &v.ExtensionsToGenerateVersion, &v.ignoredFiles)
+	s, currentTree, err := r.status(ctx)
 	if err != nil {
-		fmt.Println("Erro ao construir a árvore:", err)
 		return nil, nil, err
 	}
-
-	changes := CompareTrees(&v.Tree, currentTree)
-
-	if len(changes.Modified) == 0 && len(changes.Added) == 0 && len(changes.Removed) == 0 {
-		fmt.Println("Nenhuma mudança detectada.")
+	if s.Clean {
 		return nil, nil, nil
 	}
 
-	if len(changes.Modified) > 0 {
-		m := []Node{}
-		for _, modified := range changes.Modified {
-			if modified.Type == treeType {
-				continue
-			}
-			m = append(m, *modified)
-		}
-		if len(m) > 0 {
-			fmt.Println("Modificados:")
-			for _, modified := range m {
-				fmt.Println(modified.Path)
-			}
-		}
-	}
-	if len(changes.Added) > 0 {
-		a := []Node{}
-		for _, added := range changes.Added {
-			if added.Type == treeType {
-				continue
-			}
-			a = append(a, *added)
-		}
-		if len(a) > 0 {
-			fmt.Println("Adicionados:")
-			for _, added := range a {
-				fmt.Println(added.Path)
-			}
-		}
-	}
-	if len(changes.Removed) > 0 {
-		r := []Node{}
-		for _, removed := range changes.Removed {
-			if removed.Type == treeType {
-				continue
-			}
-			r = append(r, *removed)
-		}
-		if len(r) > 0 {
-
-			fmt.Println("Removidos:")
-			for _, removed := range r {
-				fmt.Println(removed.Path)
-			}
-		}
-	}
-
-	return changes, v, nil
+	c := CompareTrees(&r.v.Tree, currentTree)
+	r.detectSimilarRenames(c)
+	return c, r.v, nil
 }
 
 func PrintVersionFile(path string) error {
 	v, err := decompressVersionFile(path)
 	if err != nil {
-		fmt.Println("Erro ao ler a árvore salva:", err)
+		logger().Error("Erro ao ler a árvore salva", "erro", err)
 		return err
 	}
 	fileTree, err := os.OpenFile("tree.txt", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 
 	if err != nil {
-		fmt.Println("Erro ao abrir o arquivo:", err)
+		logger().Error("Erro ao abrir o arquivo", "erro", err)
 		return err
 	}
 	fileTree.WriteString("HEAD: " + v.Head + "\n")
@@ -216,158 +132,104 @@ func PrintVersionFile(path string) error {
 }
 
 func CloneRepository(path string, server string, params map[string]string) error {
-	if VerifyIfExistVersionControl(path) {
-		fmt.Println("Controle de versão já inicializado.")
-		return nil
-	}
-	err := generateVersionDir(path)
-	if err != nil {
-		fmt.Println("Erro ao criar diretório de versão:", err)
-		return err
-	}
-	fmt.Println("Controle de versão inicializado em", path)
-	fmt.Println("Clonando repositório...")
-
-	v, err := RequestClone(path, server, params)
-
-	if err != nil {
-		fmt.Println("Erro ao clonar o repositório:", err)
-		return err
-	}
-
-	if v == nil {
-		return fmt.Errorf("extensões não informadas")
-	}
+	return CloneRepositoryWithOptions(path, server, params, CloneOptions{})
+}
 
-	fmt.Println("Repositório clonado, gerando árvore de versionamento...")
-	tree, err := buildTree(path, path, &v.ExtensionsToGenerateVersion, &v.ignoredFiles)
-	if err != nil {
-		fmt.Println("Erro ao construir a árvore:", err)
-		return err
-	}
-	if tree == nil {
-		fmt.Println("A árvore está vazia.")
-		return nil
-	}
+// Opções para a operação de clone
+type CloneOptions struct {
+	TransferOptions
+	// Guarda o conteúdo dos arquivos de cada commit, como em Options
+	History bool
+}
 
-	v.Head = tree.Hash
-	v.Tree = *tree
+// CloneRepositoryWithOptions clona o repositório limitando o download a
+// opts.RateLimit bytes por segundo. Um diretório que já tem controle de
+// versão não é considerado erro.
+func CloneRepositoryWithOptions(path string, server string, params map[string]string, opts CloneOptions) error {
+	return ignoreNothingToDo(CloneRepositoryContext(context.Background(), path, server, params, opts))
+}
 
-	fmt.Println("Salvando árvore de versionamento...")
-	err = generateVersionFile(path, v)
-	if err != nil {
-		fmt.Println("Erro ao salvar a árvore:", err)
-		return err
-	}
-	return nil
+// CloneRepositoryContext clona o repositório. O download e a geração da
+// árvore são interrompidos se ctx for cancelado. Retorna ErrAlreadyInitialized
+// se o diretório já tiver controle de versão.
+func CloneRepositoryContext(ctx context.Context, path string, server string, params map[string]string, opts CloneOptions) error {
+	_, err := Clone(ctx, path, server, params, Options{Transfer: opts.TransferOptions, History: opts.History})
+	return err
 }
 
 func PullRepository(path string, server string, parameter map[string]string) error {
+	return PullRepositoryWithOptions(path, server, parameter, PullOptions{})
+}
 
-	if !VerifyIfExistVersionControl(path) {
-		return fmt.Errorf("controle de versão não inicializado")
-	}
-
-	fmt.Println("Atualizando repositório...")
-	vCurrent, err := decompressVersionFile(path)
-
-	if err != nil {
-		fmt.Println("Erro ao ler a árvore salva:", err)
-		return fmt.Errorf("erro ao ler a árvore salva: %w", err)
-	}
-
-	fmt.Println("Enviando HEAD para o servidor... " + vCurrent.Head)
-	hasModifications := sendHeadOfVersion(vCurrent.Head, server, parameter)
-
-	if !hasModifications {
-		fmt.Println("Repositório já está atualizado.")
-		return nil
-	}
-
-	fmt.Println("Repositório atualizado, gerando árvore de versionamento...")
-	err = sendTreeOfVersionForUpdate(path, &vCurrent.Tree, server, parameter)
-	if err != nil {
-		fmt.Println("Erro ao enviar a árvore:", err)
-		return fmt.Errorf("erro ao enviar a árvore: %w", err)
-	}
+// PullRepositoryWithOptions atualiza o repositório tratando os arquivos
+// alterados localmente de acordo com opts.Conflict. Os arquivos são baixados
+// com até opts.Jobs downloads simultâneos, limitados a opts.RateLimit bytes
+// por segundo. Um repositório já atualizado não é considerado erro.
+func PullRepositoryWithOptions(path string, server string, parameter map[string]string, opts PullOptions) error {
+	return ignoreNothingToDo(PullRepositoryContext(context.Background(), path, server, parameter, opts))
+}
 
-	fmt.Println("Árvore de versionamento atualizada, gerando árvore local...")
-	tree, err := buildTree(path, path, &vCurrent.ExtensionsToGenerateVersion, &vCurrent.ignoredFiles)
+// PullRepositoryContext atualiza o repositório. Se ctx for cancelado antes de
+// os arquivos serem aplicados, nada é alterado; depois disso, a atualização é
+// desfeita. Retorna ErrUpToDate se não houver nada a baixar.
+func PullRepositoryContext(ctx context.Context, path string, server string, parameter map[string]string, opts PullOptions) error {
+	r, err := OpenWithOptions(path, Options{Transfer: opts.TransferOptions, Conflict: opts.Conflict})
 	if err != nil {
-		fmt.Println("Erro ao construir a árvore:", err)
-		return fmt.Errorf("erro ao construir a árvore: %w", err)
-	}
-	if tree == nil {
-		fmt.Println("A árvore está vazia.")
-		return nil
+		return err
 	}
+	return r.Pull(ctx, server, parameter)
+}
 
-	vCurrent.Head = tree.Hash
-	vCurrent.Tree = *tree
-
-	fmt.Println("Salvando árvore de versionamento...")
-	err = generateVersionFile(path, vCurrent)
-	if err != nil {
-		fmt.Println("Erro ao salvar a árvore:", err)
-		return fmt.Errorf("erro ao salvar a árvore: %w", err)
+// Desfaz a transação de pull e retorna o erro que causou a falha
+func rollbackPull(tx *pullTransaction, cause error) error {
+	logger().Info("Desfazendo atualização")
+	if err := tx.rollback(); err != nil {
+		logger().Error("Erro ao desfazer a atualização", "erro", err)
+		return fmt.Errorf("%w (%v)", cause, err)
 	}
-	return nil
+	return cause
 }
 
 func PushRepository(path string, server string, parameters map[string]string) error {
-	if !VerifyIfExistVersionControl(path) {
-		return fmt.Errorf("controle de versão não inicializado")
-	}
-
-	fmt.Println("Enviando HEAD para o servidor...")
-	vCurrent, err := decompressVersionFile(path)
-
-	if err != nil {
-		fmt.Println("Erro ao ler a árvore salva:", err)
-		return fmt.Errorf("erro ao ler a árvore salva: %w", err)
-	}
-
-	hasModifications := sendHeadOfVersion(vCurrent.Head, server, parameters)
-	if !hasModifications {
-		fmt.Println("Repositório já está atualizado.")
-		return nil
-	}
+	return PushRepositoryWithOptions(path, server, parameters, PushOptions{})
+}
 
-	fmt.Println("Repositório atualizado, enviando árvore de versionamento...")
-	c, err := sendTreeOfVersion(&vCurrent.Tree, server, parameters)
+// Opções para a operação de push
+type PushOptions struct {
+	TransferOptions
+}
 
-	if err != nil {
-		fmt.Println("Erro ao enviar a árvore:", err)
-		return fmt.Errorf("erro ao enviar a árvore: %w", err)
-	}
+// PushRepositoryWithOptions envia as alterações com até opts.Jobs partes
+// simultâneas, limitadas a opts.RateLimit bytes por segundo. Não ter nada a
+// enviar não é considerado erro.
+func PushRepositoryWithOptions(path string, server string, parameters map[string]string, opts PushOptions) error {
+	return ignoreNothingToDo(PushRepositoryContext(context.Background(), path, server, parameters, opts))
+}
 
-	if c == nil {
-		fmt.Println("Árvore de versionamento já está atualizada.")
+// As funções sem contexto mantêm o comportamento anterior a ErrUpToDate e
+// ErrAlreadyInitialized, em que não haver nada a fazer retornava nil
+func ignoreNothingToDo(err error) error {
+	if errors.Is(err, ErrUpToDate) || errors.Is(err, ErrAlreadyInitialized) {
 		return nil
 	}
+	return err
+}
 
-	buf, err := CompressFilesToSend(*c, path)
-
-	if err != nil {
-		fmt.Println("Erro ao compactar os arquivos:", err)
-		return fmt.Errorf("erro ao compactar os arquivos: %w", err)
-	}
-
-	err = sendFilesToServer(buf, server, parameters)
-
+// PushRepositoryContext envia as alterações. O envio é interrompido se ctx
+// for cancelado e pode ser retomado depois. Retorna ErrUpToDate se não houver
+// nada a enviar.
+func PushRepositoryContext(ctx context.Context, path string, server string, parameters map[string]string, opts PushOptions) error {
+	r, err := OpenWithOptions(path, Options{Transfer: opts.TransferOptions})
 	if err != nil {
-		fmt.Println("Erro ao enviar os arquivos:", err)
-		return fmt.Errorf("erro ao enviar os arquivos: %w", err)
+		return err
 	}
-
-	fmt.Println("Arquivos enviados com sucesso.")
-	return nil
+	return r.Push(ctx, server, parameters)
 }
 
 func GetTreeControlVersion(path string) (*Node, error) {
 	v, err := decompressVersionFile(path)
 	if err != nil {
-		fmt.Println("Erro ao ler a árvore salva:", err)
+		logger().Error("Erro ao ler a árvore salva", "erro", err)
 		return nil, err
 	}
 	return &v.Tree, nil
@@ -0,0 +1,398 @@
+package tinygit
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+const (
+	stagingDirName = "staging"
+	backupDirName  = "backup"
+	journalName    = "pull-journal.json"
+)
+
+// Operação planejada de uma transação de pull
+type txOp struct {
+	Path    string `json:"path"`
+	Existed bool   `json:"existed"`
+	Remove  bool   `json:"remove"`
+}
+
+// pullTransaction prepara os arquivos recebidos em .tinygit/staging e só os
+// aplica no diretório de trabalho depois de verificados. Tudo que for
+// sobrescrito ou removido é movido para .tinygit/backup, permitindo desfazer
+// a operação caso algum passo falhe.
+type pullTransaction struct {
+	rootPath   string
+	stagingDir string
+	backupDir  string
+	journal    string
+	files      []string
+	removed    []string
+	ops        []txOp
+	release    *SignedRelease
+	// Hash da árvore do servidor, informado no manifesto
+	head string
+}
+
+func newPullTransaction(rootPath string) (*pullTransaction, error) {
+	dirVersion := filepath.Join(rootPath, versionDirName)
+	tx := &pullTransaction{
+		rootPath:   rootPath,
+		stagingDir: filepath.Join(dirVersion, stagingDirName),
+		backupDir:  filepath.Join(dirVersion, backupDirName),
+		journal:    filepath.Join(dirVersion, journalName),
+	}
+
+	// Remove restos de uma transação anterior antes de começar
+	if err := tx.cleanup(); err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(tx.stagingDir, 0700); err != nil {
+		return nil, fmt.Errorf("erro ao criar diretório de staging: %v", err)
+	}
+	if err := os.MkdirAll(tx.backupDir, 0700); err != nil {
+		return nil, fmt.Errorf("erro ao criar diretório de backup: %v", err)
+	}
+
+	return tx, nil
+}
+
+// Desfaz uma transação interrompida (por exemplo, se o processo foi encerrado
+// durante a aplicação dos arquivos)
+func recoverPullTransaction(rootPath string) error {
+	dirVersion := filepath.Join(rootPath, versionDirName)
+	tx := &pullTransaction{
+		rootPath:   rootPath,
+		stagingDir: filepath.Join(dirVersion, stagingDirName),
+		backupDir:  filepath.Join(dirVersion, backupDirName),
+		journal:    filepath.Join(dirVersion, journalName),
+	}
+
+	raw, err := os.ReadFile(tx.journal)
+	if os.IsNotExist(err) {
+		return tx.cleanup()
+	}
+	if err != nil {
+		return fmt.Errorf("erro ao ler o diário da transação: %v", err)
+	}
+
+	if err := json.Unmarshal(raw, &tx.ops); err != nil {
+		return fmt.Errorf("erro ao decodificar o diário da transação: %v", err)
+	}
+
+	logger().Info("Desfazendo atualização interrompida")
+	if err := tx.rollback(); err != nil {
+		return err
+	}
+	return tx.cleanup()
+}
+
+// Extrai o arquivo zip recebido para a área de staging. Quando o zip possui
+// um manifesto, cada arquivo é verificado contra o hash e o tamanho informados
+// e os caminhos removidos são registrados na transação. O andamento é
+// informado em p e a extração é interrompida se ctx for cancelado.
+func (tx *pullTransaction) stageZip(ctx context.Context, zipPath string, p *progress) (*Manifest, error) {
+	r, err := zip.OpenReader(zipPath)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	m, err := readManifestFromZip(&r.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	tx.release, err = readReleaseFromZip(&r.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	expected := map[string]ManifestEntry{}
+	if m != nil {
+		tx.head = m.Head
+		for _, entry := range m.files() {
+			expected[entry.Path] = entry
+		}
+	}
+
+	isData := func(f *zip.File) bool {
+		return !f.FileInfo().IsDir() && f.Name != manifestEntryName && f.Name != releaseEntryName
+	}
+
+	var totalBytes int64
+	totalFiles := 0
+	for _, f := range r.File {
+		if isData(f) {
+			totalBytes += int64(f.UncompressedSize64)
+			totalFiles++
+		}
+	}
+	p.start(PhaseExtract, totalBytes, totalFiles)
+
+	for _, f := range r.File {
+		if !isData(f) {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		fpath, err := safeJoin(tx.stagingDir, f.Name)
+		if err != nil {
+			return nil, err
+		}
+
+		err = extractZipFile(f, fpath)
+		if err != nil {
+			return nil, err
+		}
+
+		relPath, err := filepath.Rel(tx.stagingDir, fpath)
+		if err != nil {
+			return nil, err
+		}
+
+		if m != nil {
+			entry, found := expected[relPath]
+			if !found {
+				return nil, fmt.Errorf("%s: arquivo não consta no manifesto", relPath)
+			}
+			err = verifyStagedFile(fpath, entry)
+			if err != nil {
+				return nil, err
+			}
+			delete(expected, relPath)
+		}
+
+		tx.files = append(tx.files, relPath)
+		p.add(int64(f.UncompressedSize64), 1)
+	}
+
+	for path := range expected {
+		return nil, fmt.Errorf("%s: arquivo do manifesto não recebido", path)
+	}
+
+	if m != nil {
+		tx.remove(m.removedPaths()...)
+	}
+
+	p.finish()
+	return m, nil
+}
+
+// Verifica se o arquivo preparado corresponde à entrada do manifesto pelo
+// tamanho e pelo hash do conteúdo. O hash do nó inclui a data de modificação
+// e não serve para verificar o conteúdo: entradas de servidores antigos, sem
+// o hash do conteúdo, são verificadas apenas pelo tamanho.
+func verifyStagedFile(fpath string, entry ManifestEntry) error {
+	info, err := os.Stat(fpath)
+	if err != nil {
+		return err
+	}
+	if info.Size() != entry.Size {
+		return &IntegrityError{Path: entry.Path, Field: "tamanho", Expected: strconv.FormatInt(entry.Size, 10), Actual: strconv.FormatInt(info.Size(), 10)}
+	}
+
+	if entry.ContentHash == "" {
+		logger().Warn("Manifesto sem hash do conteúdo, verificando apenas o tamanho", "caminho", entry.Path)
+		return nil
+	}
+
+	contentHash, err := calculateContentHash(fpath)
+	if err != nil {
+		return err
+	}
+	if !CompareHashes(contentHash, entry.ContentHash) {
+		return &IntegrityError{Path: entry.Path, Field: "conteúdo", Expected: entry.ContentHash, Actual: contentHash}
+	}
+	return nil
+}
+
+// Registra os caminhos que devem ser removidos ao aplicar a transação
+func (tx *pullTransaction) remove(paths ...string) {
+	tx.removed = append(tx.removed, paths...)
+}
+
+// Retira da transação os caminhos informados, mantendo a versão local
+func (tx *pullTransaction) skip(paths map[string][]string) {
+	files := tx.files[:0]
+	for _, rel := range tx.files {
+		if _, found := paths[rel]; !found {
+			files = append(files, rel)
+		}
+	}
+	tx.files = files
+
+	removed := tx.removed[:0]
+	for _, rel := range tx.removed {
+		if _, found := paths[rel]; !found {
+			removed = append(removed, rel)
+		}
+	}
+	tx.removed = removed
+}
+
+// Aplica os arquivos preparados e as remoções no diretório de trabalho.
+// Em caso de erro, o diretório é restaurado a partir do backup.
+func (tx *pullTransaction) apply() error {
+	versionPath := filepath.Join(versionDirName, versionFileName)
+	versionExisted := exists(filepath.Join(tx.rootPath, versionPath))
+	tx.ops = []txOp{{Path: versionPath, Existed: versionExisted}}
+	for _, rel := range tx.files {
+		tx.ops = append(tx.ops, txOp{Path: rel, Existed: exists(filepath.Join(tx.rootPath, rel))})
+	}
+	for _, rel := range tx.removed {
+		if !exists(filepath.Join(tx.rootPath, rel)) {
+			continue
+		}
+		tx.ops = append(tx.ops, txOp{Path: rel, Existed: true, Remove: true})
+	}
+
+	err := tx.writeJournal()
+	if err != nil {
+		return err
+	}
+
+	// O arquivo de versão é copiado para que possa ser restaurado caso a
+	// gravação da nova árvore falhe
+	if versionExisted {
+		err = copyFile(filepath.Join(tx.rootPath, versionPath), filepath.Join(tx.backupDir, versionPath))
+		if err != nil {
+			tx.cleanup()
+			return fmt.Errorf("erro ao copiar o arquivo de versão: %v", err)
+		}
+	}
+
+	for _, op := range tx.ops[1:] {
+		err = tx.applyOp(op)
+		if err != nil {
+			if rbErr := tx.rollback(); rbErr != nil {
+				logger().Error("Erro ao desfazer a atualização", "erro", rbErr)
+			}
+			return fmt.Errorf("erro ao aplicar %s: %v", op.Path, err)
+		}
+	}
+
+	return nil
+}
+
+func (tx *pullTransaction) applyOp(op txOp) error {
+	dst := filepath.Join(tx.rootPath, op.Path)
+
+	if op.Existed {
+		backup := filepath.Join(tx.backupDir, op.Path)
+		if err := os.MkdirAll(filepath.Dir(backup), os.ModePerm); err != nil {
+			return err
+		}
+		if err := os.Rename(dst, backup); err != nil {
+			return err
+		}
+	}
+
+	if op.Remove {
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dst), os.ModePerm); err != nil {
+		return err
+	}
+	return os.Rename(filepath.Join(tx.stagingDir, op.Path), dst)
+}
+
+// Restaura o diretório de trabalho para o estado anterior à transação
+func (tx *pullTransaction) rollback() error {
+	var firstErr error
+	for i := len(tx.ops) - 1; i >= 0; i-- {
+		op := tx.ops[i]
+		dst := filepath.Join(tx.rootPath, op.Path)
+		backup := filepath.Join(tx.backupDir, op.Path)
+
+		if !op.Existed {
+			// Arquivo novo: basta removê-lo, se chegou a ser aplicado
+			if err := os.RemoveAll(dst); err != nil && firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+
+		if !exists(backup) {
+			// A operação não chegou a ser executada
+			continue
+		}
+
+		if err := os.RemoveAll(dst); err != nil && firstErr == nil {
+			firstErr = err
+			continue
+		}
+		// O diretório pode ter sido removido por ter ficado vazio
+		if err := os.MkdirAll(filepath.Dir(dst), os.ModePerm); err != nil && firstErr == nil {
+			firstErr = err
+			continue
+		}
+		if err := os.Rename(backup, dst); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	if firstErr != nil {
+		return fmt.Errorf("erro ao restaurar o backup: %v", firstErr)
+	}
+	return nil
+}
+
+// Remove a área de staging, o backup e o diário da transação
+func (tx *pullTransaction) cleanup() error {
+	if err := os.RemoveAll(tx.stagingDir); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(tx.backupDir); err != nil {
+		return err
+	}
+	return os.RemoveAll(tx.journal)
+}
+
+func (tx *pullTransaction) writeJournal() error {
+	b, err := json.Marshal(tx.ops)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(tx.journal, b, 0600)
+}
+
+func exists(path string) bool {
+	_, err := os.Lstat(path)
+	return err == nil
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	if err := os.MkdirAll(filepath.Dir(dst), os.ModePerm); err != nil {
+		return err
+	}
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(out, in)
+	if err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
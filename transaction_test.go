@@ -0,0 +1,209 @@
+package tinygit
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Cria uma transação em um diretório temporário com os arquivos informados
+// (caminho com barras → conteúdo) no diretório de trabalho
+func newTestTransaction(t *testing.T, files map[string]string) *pullTransaction {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		writeTestFile(t, dir, name, content)
+	}
+	tx, err := newPullTransaction(dir)
+	if err != nil {
+		t.Fatalf("newPullTransaction: %v", err)
+	}
+	return tx
+}
+
+// Prepara um arquivo recebido do servidor na transação
+func stageTestFile(t *testing.T, tx *pullTransaction, name, content string) {
+	t.Helper()
+	writeTestFile(t, tx.stagingDir, name, content)
+	tx.files = append(tx.files, filepath.FromSlash(name))
+}
+
+// Verifica o conteúdo dos arquivos do diretório de trabalho. Conteúdo vazio
+// indica que o arquivo não deve existir.
+func assertFiles(t *testing.T, dir string, want map[string]string) {
+	t.Helper()
+	for name, content := range want {
+		b, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(name)))
+		if content == "" {
+			if err == nil {
+				t.Errorf("%s não deveria existir", name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if string(b) != content {
+			t.Errorf("%s = %q, esperado %q", name, b, content)
+		}
+	}
+}
+
+var transactionFiles = map[string]string{
+	"a.txt":     "a",
+	"dir/b.txt": "b",
+	"dir/c.txt": "c",
+}
+
+// Estado do diretório antes da transação
+var transactionOriginal = map[string]string{
+	"a.txt":     "a",
+	"dir/b.txt": "b",
+	"dir/c.txt": "c",
+	"new.txt":   "",
+}
+
+func TestPullTransactionApply(t *testing.T) {
+	tx := newTestTransaction(t, transactionFiles)
+	stageTestFile(t, tx, "a.txt", "a2")
+	stageTestFile(t, tx, "new.txt", "n")
+	tx.remove(filepath.FromSlash("dir/c.txt"))
+
+	if err := tx.apply(); err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	assertFiles(t, tx.rootPath, map[string]string{"a.txt": "a2", "new.txt": "n", "dir/b.txt": "b", "dir/c.txt": ""})
+
+	if err := tx.cleanup(); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []string{tx.stagingDir, tx.backupDir, tx.journal} {
+		if exists(p) {
+			t.Errorf("%s não foi removido", p)
+		}
+	}
+}
+
+func TestPullTransactionRollbackOnFailure(t *testing.T) {
+	tx := newTestTransaction(t, transactionFiles)
+	stageTestFile(t, tx, "a.txt", "a2")
+	stageTestFile(t, tx, "new.txt", "n")
+	// O último arquivo não está no staging: sua aplicação falha depois de
+	// os anteriores terem sido aplicados
+	tx.files = append(tx.files, filepath.FromSlash("dir/b.txt"))
+	tx.remove(filepath.FromSlash("dir/c.txt"))
+
+	if err := tx.apply(); err == nil {
+		t.Fatal("apply sem erro, esperado falha ao aplicar dir/b.txt")
+	}
+	assertFiles(t, tx.rootPath, transactionOriginal)
+}
+
+func TestRecoverPullTransaction(t *testing.T) {
+	tests := []struct {
+		name string
+		// Operações aplicadas antes da interrupção, sem contar o arquivo de
+		// versão; -1 aplica a transação inteira
+		applied int
+	}{
+		{name: "antes de aplicar", applied: 0},
+		{name: "aplicação parcial", applied: 2},
+		{name: "depois de aplicar", applied: -1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tx := newTestTransaction(t, transactionFiles)
+			stageTestFile(t, tx, "a.txt", "a2")
+			stageTestFile(t, tx, "new.txt", "n")
+			tx.remove(filepath.FromSlash("dir/c.txt"), filepath.FromSlash("dir/b.txt"))
+
+			if tt.applied < 0 {
+				if err := tx.apply(); err != nil {
+					t.Fatalf("apply: %v", err)
+				}
+			} else {
+				// Reproduz o início de apply: o diário é gravado antes de
+				// qualquer arquivo ser movido
+				tx.ops = []txOp{{Path: filepath.Join(versionDirName, versionFileName), Existed: false}}
+				for _, rel := range tx.files {
+					tx.ops = append(tx.ops, txOp{Path: rel, Existed: exists(filepath.Join(tx.rootPath, rel))})
+				}
+				for _, rel := range tx.removed {
+					tx.ops = append(tx.ops, txOp{Path: rel, Existed: true, Remove: true})
+				}
+				if err := tx.writeJournal(); err != nil {
+					t.Fatal(err)
+				}
+				for _, op := range tx.ops[1 : 1+tt.applied] {
+					if err := tx.applyOp(op); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+
+			if err := recoverPullTransaction(tx.rootPath); err != nil {
+				t.Fatalf("recoverPullTransaction: %v", err)
+			}
+			assertFiles(t, tx.rootPath, transactionOriginal)
+			for _, p := range []string{tx.stagingDir, tx.backupDir, tx.journal} {
+				if exists(p) {
+					t.Errorf("%s não foi removido", p)
+				}
+			}
+		})
+	}
+}
+
+func TestRecoverPullTransactionWithoutJournal(t *testing.T) {
+	tx := newTestTransaction(t, transactionFiles)
+	stageTestFile(t, tx, "a.txt", "a2")
+
+	if err := recoverPullTransaction(tx.rootPath); err != nil {
+		t.Fatalf("recoverPullTransaction: %v", err)
+	}
+	assertFiles(t, tx.rootPath, transactionOriginal)
+	if exists(tx.stagingDir) {
+		t.Error("staging não foi removido")
+	}
+}
+
+func TestVerifyStagedFile(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, dir, "a.txt", "conteúdo")
+	fpath := filepath.Join(dir, "a.txt")
+	// A data de modificação não faz parte da verificação
+	old := time.Date(2001, 2, 3, 4, 5, 6, 0, time.FixedZone("UTC-3", -3*3600))
+	if err := os.Chtimes(fpath, old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		entry ManifestEntry
+		field string
+	}{
+		{name: "íntegro", entry: ManifestEntry{Path: "a.txt", Size: 9, ContentHash: contentHash("conteúdo"), Hash: "outro"}},
+		{name: "tamanho divergente", entry: ManifestEntry{Path: "a.txt", Size: 3, ContentHash: contentHash("conteúdo")}, field: "tamanho"},
+		{name: "conteúdo divergente", entry: ManifestEntry{Path: "a.txt", Size: 9, ContentHash: contentHash("conteúdx")}, field: "conteúdo"},
+		{name: "sem hash do conteúdo", entry: ManifestEntry{Path: "a.txt", Size: 9, Hash: "outro"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := verifyStagedFile(fpath, tt.entry)
+			if tt.field == "" {
+				if err != nil {
+					t.Errorf("verifyStagedFile: %v", err)
+				}
+				return
+			}
+			var integrityErr *IntegrityError
+			if !errors.As(err, &integrityErr) || integrityErr.Field != tt.field {
+				t.Errorf("verifyStagedFile: %v, esperado erro de %s", err, tt.field)
+			}
+		})
+	}
+}
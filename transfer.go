@@ -0,0 +1,214 @@
+package tinygit
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// Número padrão de downloads e uploads simultâneos
+	defaultTransferJobs = 4
+	// Tamanho máximo de cada leitura limitada, para que o limite seja
+	// respeitado mesmo com buffers grandes
+	limitedChunkSize = 32 << 10
+)
+
+// TransferOptions controla a concorrência, a banda e o acompanhamento das
+// transferências
+type TransferOptions struct {
+	// Número de downloads ou uploads simultâneos. Zero usa o padrão.
+	Jobs int
+	// Limite de banda, em bytes por segundo, compartilhado por todas as
+	// transferências simultâneas. Zero não limita.
+	RateLimit int64
+	// Recebe o andamento de cada etapa da operação. Pode ser nil.
+	Progress ProgressReporter
+	// Tempos limite e novas tentativas das requisições
+	Client ClientOptions
+}
+
+// Estado compartilhado pelas requisições de uma mesma operação. O contexto
+// cancela todas as requisições e esperas da operação.
+type transfer struct {
+	ctx      context.Context
+	jobs     int
+	limiter  *rateLimiter
+	progress *progress
+	client   *httpClient
+}
+
+func newTransfer(ctx context.Context, opts TransferOptions) *transfer {
+	jobs := opts.Jobs
+	if jobs <= 0 {
+		jobs = defaultTransferJobs
+	}
+	return &transfer{
+		ctx:      ctx,
+		jobs:     jobs,
+		limiter:  newRateLimiter(opts.RateLimit),
+		progress: newProgress(opts.Progress),
+		client:   newHTTPClient(opts.Client),
+	}
+}
+
+// Executa fn(0) ... fn(count-1) com até t.jobs chamadas simultâneas. Após o
+// primeiro erro ou o cancelamento do contexto nenhuma nova chamada é iniciada
+// e o erro é retornado.
+func (t *transfer) run(count int, fn func(i int) error) error {
+	jobs := t.jobs
+	if jobs > count {
+		jobs = count
+	}
+
+	var (
+		mu       sync.Mutex
+		next     int
+		firstErr error
+		wg       sync.WaitGroup
+	)
+
+	for w := 0; w < jobs; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				mu.Lock()
+				if firstErr == nil {
+					firstErr = t.ctx.Err()
+				}
+				if firstErr != nil || next >= count {
+					mu.Unlock()
+					return
+				}
+				i := next
+				next++
+				mu.Unlock()
+
+				if err := fn(i); err != nil {
+					mu.Lock()
+					if firstErr == nil {
+						firstErr = err
+					}
+					mu.Unlock()
+					return
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+	return firstErr
+}
+
+// Envia a requisição no contexto da operação
+func (t *transfer) do(req *http.Request) (*http.Response, error) {
+	return t.client.do(req.WithContext(t.ctx))
+}
+
+// Envia no contexto da operação uma requisição POST que pode ser repetida
+func (t *transfer) doSafe(req *http.Request) (*http.Response, error) {
+	return t.client.doSafe(req.WithContext(t.ctx))
+}
+
+// Envia no contexto da operação uma requisição que gera um arquivo zip
+func (t *transfer) doArchive(req *http.Request) (*http.Response, error) {
+	return t.client.doArchive(req.WithContext(t.ctx))
+}
+
+// Aguarda d antes de uma nova tentativa, retornando antes se a operação for
+// cancelada
+func (t *transfer) sleep(d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-t.ctx.Done():
+		return t.ctx.Err()
+	}
+}
+
+// Retorna um leitor de r que respeita o limite de banda
+func (t *transfer) reader(r io.Reader) io.Reader {
+	if t == nil || t.limiter == nil {
+		return r
+	}
+	return &limitedReader{ctx: t.ctx, r: r, limiter: t.limiter}
+}
+
+// Balde de fichas: cada byte transferido consome uma ficha e as fichas são
+// repostas a rate por segundo
+type rateLimiter struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// Retorna nil se rate não for positivo
+func newRateLimiter(rate int64) *rateLimiter {
+	if rate <= 0 {
+		return nil
+	}
+	burst := float64(rate)
+	if burst < limitedChunkSize {
+		burst = limitedChunkSize
+	}
+	return &rateLimiter{rate: float64(rate), burst: burst, tokens: burst, last: time.Now()}
+}
+
+// Consome n fichas, aguardando se necessário. Retorna o erro do contexto se
+// ele for cancelado durante a espera.
+func (l *rateLimiter) wait(ctx context.Context, n int) error {
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+	l.tokens -= float64(n)
+
+	var delay time.Duration
+	if l.tokens < 0 {
+		delay = time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+	l.mu.Unlock()
+
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+type limitedReader struct {
+	ctx     context.Context
+	r       io.Reader
+	limiter *rateLimiter
+}
+
+func (lr *limitedReader) Read(p []byte) (int, error) {
+	if len(p) > limitedChunkSize {
+		p = p[:limitedChunkSize]
+	}
+	n, err := lr.r.Read(p)
+	if n > 0 {
+		if werr := lr.limiter.wait(lr.ctx, n); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
@@ -3,6 +3,7 @@ package tinygit
 import (
 	"archive/zip"
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -10,6 +11,7 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
 
@@ -17,204 +19,294 @@ import (
 )
 
 func RequestClone(path string, serverUrl string, parameters map[string]string) (*Versioning, error) {
+	return requestClone(path, serverUrl, parameters, newTransfer(context.Background(), TransferOptions{}))
+}
+
+func requestClone(path string, serverUrl string, parameters map[string]string, t *transfer) (*Versioning, error) {
 	// Verificar se o diretório já existe
 	if _, err := os.Stat(path); os.IsNotExist(err) {
 		return nil, errors.New("diretório não existe")
 	}
 
-	u, err := parseUrlParameter(serverUrl, "clone", parameters)
+	zipPath, extensions, err := downloadClone(path, serverUrl, parameters, t)
 	if err != nil {
 		return nil, err
 	}
+	defer os.Remove(zipPath)
 
-	resp, err := http.Get(u)
+	if len(extensions) == 0 {
+		return nil, errors.New("extensão de configuração não encontrada")
+	}
+
+	// Os arquivos são verificados na área de staging antes de serem aplicados
+	tx, err := newPullTransaction(path)
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
+	defer tx.cleanup()
 
-	fmt.Println("Status:", resp.Status)
+	_, err = tx.stageZip(t.ctx, zipPath, t.progress)
+	if err != nil {
+		return nil, err
+	}
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, errors.New("erro ao baixar o repositório")
+	err = tx.verifySignature(nil)
+	if err != nil {
+		return nil, err
 	}
 
-	id := uuid.New().String()
-	tempFile, err := os.CreateTemp("", id+".zip")
+	err = tx.apply()
 	if err != nil {
 		return nil, err
 	}
 
-	defer os.Remove(tempFile.Name())
+	v := Versioning{
+		ExtensionsToGenerateVersion: extensions,
+	}
+
+	return &v, nil
+}
 
-	_, err = io.Copy(tempFile, resp.Body)
+// Baixa o zip do clone e retorna seu caminho e as extensões versionadas. O
+// download é retomável quando o servidor gera o zip em cache.
+func downloadClone(path string, serverUrl string, parameters map[string]string, t *transfer) (string, []string, error) {
+	info, err := requestArchive(http.MethodGet, "clone/archive", nil, serverUrl, parameters, t)
+	if err == nil {
+		zipPath, err := downloadArchive(path, info, serverUrl, parameters, t)
+		return zipPath, info.Extensions, err
+	}
+	if !errors.Is(err, errArchiveUnsupported) {
+		return "", nil, err
+	}
+
+	u, err := parseUrlParameter(serverUrl, "clone", parameters)
 	if err != nil {
-		return nil, err
+		return "", nil, err
 	}
 
-	err = tempFile.Close()
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return "", nil, err
+	}
 
+	resp, err := t.doArchive(req)
 	if err != nil {
-		return nil, err
+		return "", nil, err
 	}
+	defer resp.Body.Close()
+
+	logger().Debug("Resposta do clone", "status", resp.Status)
 
-	err = unzipFiles(tempFile.Name(), path)
+	if resp.StatusCode != http.StatusOK {
+		return "", nil, newHTTPError("baixar o repositório", resp)
+	}
+
+	t.progress.start(PhaseDownload, resp.ContentLength, 0)
+	zipPath, err := saveTempZip(t.progress.reader(t.reader(resp.Body)))
 	if err != nil {
-		return nil, err
+		return "", nil, err
 	}
+	t.progress.finish()
 
-	ext := resp.Header.Get("Config-Ext")
+	var extensions []string
+	if ext := resp.Header.Get("Config-Ext"); ext != "" {
+		extensions = strings.Split(ext, ",")
+	}
 
-	if ext == "" {
-		return nil, errors.New("extensão de configuração não encontrada")
+	return zipPath, extensions, nil
+}
+
+// Grava o corpo da resposta em um arquivo temporário
+func saveTempZip(r io.Reader) (string, error) {
+	id := uuid.New().String()
+	tempFile, err := os.CreateTemp("", id+".zip")
+	if err != nil {
+		return "", err
 	}
 
-	v := Versioning{
-		ExtensionsToGenerateVersion: strings.Split(ext, ","),
+	_, err = io.Copy(tempFile, r)
+
+	if err != nil {
+		tempFile.Close()
+		os.Remove(tempFile.Name())
+		return "", err
 	}
 
-	return &v, nil
+	err = tempFile.Close()
+
+	if err != nil {
+		os.Remove(tempFile.Name())
+		return "", err
+	}
+
+	return tempFile.Name(), nil
 }
 
-func sendHeadOfVersion(head string, serverUrl string, parameters map[string]string) bool {
+// Envia o HEAD local e retorna ErrUpToDate se o servidor estiver na mesma
+// versão
+func sendHeadOfVersion(head string, serverUrl string, parameters map[string]string, t *transfer) error {
 	parameters["head"] = head
 	u, err := parseUrlParameter(serverUrl, "head", parameters)
 	if err != nil {
-		fmt.Println("Erro ao criar URL:", err)
-		return false
+		return fmt.Errorf("erro ao criar URL: %w", err)
 	}
 
 	req, err := http.NewRequest(http.MethodGet, u, nil)
 	if err != nil {
-		fmt.Println("Erro ao criar requisição:", err)
-		return false
+		return fmt.Errorf("erro ao criar requisição: %w", err)
 	}
 
-	client := http.Client{}
-	resp, err := client.Do(req)
+	resp, err := t.do(req)
 	if err != nil {
-		fmt.Println("Erro ao enviar HEAD:", err)
-		return false
+		return fmt.Errorf("erro ao enviar HEAD: %w", err)
 	}
+	defer resp.Body.Close()
 
 	if resp.StatusCode == http.StatusNotModified {
-		return false
+		return ErrUpToDate
 	}
 
 	if resp.StatusCode != http.StatusOK {
-		fmt.Println("Erro ao enviar HEAD:", resp.Status)
-		return false
+		return newHTTPError("enviar HEAD", resp)
 	}
 
-	return true
+	return nil
 }
 
-func sendTreeOfVersionForUpdate(rootPath string, tree *Node, serverUrl string, parameters map[string]string) error {
-	u, err := parseUrlParameter(serverUrl, "pull", parameters)
+// sendTreeOfVersionForUpdate envia a árvore local e prepara os arquivos
+// recebidos em uma transação, que deve ser aplicada pelo chamador
+func sendTreeOfVersionForUpdate(rootPath string, tree *Node, serverUrl string, parameters map[string]string, t *transfer) (*pullTransaction, error) {
+	logger().Info("Enviando árvore para o servidor")
+
+	b, err := json.Marshal(tree)
 	if err != nil {
-		fmt.Println("aqui 3", err)
-		return err
+		return nil, err
 	}
 
-	fmt.Println("Enviando árvore para o servidor...")
+	pm, err := requestPullManifest(b, serverUrl, parameters, t)
+	if err == nil {
+		logger().Info("Árvore enviada com sucesso! Baixando arquivos")
+		tx, err := newPullTransaction(rootPath)
+		if err != nil {
+			return nil, err
+		}
 
-	b, err := json.Marshal(tree)
-	if err != nil {
-		return err
+		tx.release = pm.Release
+		err = tx.stageObjects(pm.Manifest, serverUrl, parameters, t)
+		if err == nil {
+			err = tx.verifySignature(tree)
+		}
+		if err != nil {
+			tx.cleanup()
+			return nil, err
+		}
+		return tx, nil
+	}
+	if !errors.Is(err, errObjectsUnsupported) {
+		return nil, err
 	}
 
-	req, err := http.NewRequest(http.MethodPost, u, bytes.NewReader(b))
+	// Servidores sem download por objeto enviam todos os arquivos em um zip
+	var zipPath, removedRaw string
+	info, err := requestArchive(http.MethodPost, "pull/archive", b, serverUrl, parameters, t)
+	if err == nil {
+		zipPath, err = downloadArchive(rootPath, info, serverUrl, parameters, t)
+	} else if errors.Is(err, errArchiveUnsupported) {
+		zipPath, removedRaw, err = downloadPull(b, serverUrl, parameters, t)
+	}
 	if err != nil {
-		fmt.Println("aqui 1", err)
-		return err
+		return nil, err
 	}
-	req.Header.Set("Content-Type", "application/json")
+	defer os.Remove(zipPath)
 
-	client := http.Client{}
+	logger().Info("Árvore enviada com sucesso! Processando resposta")
 
-	resp, err := client.Do(req)
+	tx, err := newPullTransaction(rootPath)
 	if err != nil {
-		fmt.Println("aqui 2", err)
-		return err
+		return nil, err
 	}
 
-	defer resp.Body.Close()
+	m, err := tx.stageZip(t.ctx, zipPath, t.progress)
+	if err != nil {
+		tx.cleanup()
+		return nil, err
+	}
 
-	if resp.StatusCode != http.StatusOK {
-		return errors.New("erro ao enviar árvore, status: " + resp.Status)
+	// Servidores antigos enviam os caminhos removidos em um cabeçalho
+	if m == nil && removedRaw != "" {
+		tx.remove(strings.Split(removedRaw, ",")...)
 	}
-	fmt.Println("Árvore enviada com sucesso! Processando resposta...")
-	//CRIAR ARQUIVO TEMPORÁRIO
-	id := uuid.New().String()
-	tempFile, err := os.CreateTemp("", id+".zip")
+
+	err = tx.verifySignature(tree)
 	if err != nil {
-		return err
+		tx.cleanup()
+		return nil, err
 	}
 
-	defer os.Remove(tempFile.Name())
-
-	_, err = io.Copy(tempFile, resp.Body)
+	return tx, nil
+}
 
+// Baixa o zip do pull em uma única requisição, para servidores sem cache.
+// Retorna o caminho do zip e o cabeçalho Removed enviado por servidores antigos.
+func downloadPull(tree []byte, serverUrl string, parameters map[string]string, t *transfer) (string, string, error) {
+	u, err := parseUrlParameter(serverUrl, "pull", parameters)
 	if err != nil {
-		return err
+		return "", "", err
 	}
 
-	err = tempFile.Close()
-
+	req, err := http.NewRequest(http.MethodPost, u, bytes.NewReader(tree))
 	if err != nil {
-		return err
+		return "", "", err
 	}
+	req.Header.Set("Content-Type", "application/json")
 
-	err = unzipFiles(tempFile.Name(), filepath.Join(rootPath))
+	resp, err := t.doArchive(req)
 	if err != nil {
-		return err
+		return "", "", err
 	}
 
-	removedRaw := resp.Header.Get("Removed")
-	var removed []string
-	if removedRaw != "" {
-		removed = strings.Split(removedRaw, ",")
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", "", newHTTPError("enviar árvore", resp)
 	}
 
-	if len(removed) > 0 {
-		fmt.Println("Removendo:")
-		for _, r := range removed {
-			fmt.Println(r)
-			err := os.RemoveAll(filepath.Join(rootPath, r))
-			if err != nil {
-				fmt.Println("Erro ao remover o arquivo:", err)
-			}
-		}
+	t.progress.start(PhaseDownload, resp.ContentLength, 0)
+	zipPath, err := saveTempZip(t.progress.reader(t.reader(resp.Body)))
+	if err != nil {
+		return "", "", err
 	}
+	t.progress.finish()
 
-	return nil
+	return zipPath, resp.Header.Get("Removed"), nil
 }
 
-func sendTreeOfVersion(tree *Node, serverUrl string, paramenters map[string]string) (*Changes, error) {
+func sendTreeOfVersion(tree *Node, serverUrl string, paramenters map[string]string, t *transfer) (*Changes, error) {
 	u, err := parseUrlParameter(serverUrl, "tree", paramenters)
 	if err != nil {
 		return nil, err
 	}
-	req, err := http.NewRequest(http.MethodPost, u, nil)
+
+	b, err := json.Marshal(tree)
 	if err != nil {
 		return nil, err
 	}
 
-	b, err := json.Marshal(tree)
+	req, err := http.NewRequest(http.MethodPost, u, bytes.NewReader(b))
 	if err != nil {
 		return nil, err
 	}
+	req.Header.Set(renamesHeader, "1")
 
-	req.Body = io.NopCloser(strings.NewReader(string(b)))
-
-	client := http.Client{}
-	resp, err := client.Do(req)
+	// A comparação de árvores não altera o servidor
+	resp, err := t.doSafe(req)
 	if err != nil {
 		return nil, err
 	}
+	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, errors.New("erro ao enviar árvore")
+		return nil, newHTTPError("enviar árvore", resp)
 	}
 
 	c := Changes{
@@ -229,90 +321,132 @@ func sendTreeOfVersion(tree *Node, serverUrl string, paramenters map[string]stri
 		return nil, err
 	}
 
-	if len(c.Modified) > 0 {
-		m := []Node{}
-		for _, modified := range c.Modified {
-			if modified.Type == treeType {
-				continue
-			}
-			m = append(m, *modified)
-		}
-		if len(m) > 0 {
-			fmt.Println("Modificados:")
-			for _, modified := range m {
-				fmt.Println(modified.Path)
-			}
-		}
+	logChanges(&c)
+
+	return &c, nil
+}
+
+// Baixa a árvore salva no servidor
+func requestRemoteTree(serverUrl string, parameters map[string]string, t *transfer) (*Node, error) {
+	u, err := parseUrlParameter(serverUrl, "tree", parameters)
+	if err != nil {
+		return nil, err
 	}
 
-	if len(c.Added) > 0 {
-		a := []Node{}
-		for _, added := range c.Added {
-			if added.Type == treeType {
-				continue
-			}
-			a = append(a, *added)
-		}
-		if len(a) > 0 {
-			fmt.Println("Adicionados:")
-			for _, added := range a {
-				fmt.Println(added.Path)
-			}
-		}
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
 	}
 
-	if len(c.Removed) > 0 {
-		r := []Node{}
-		for _, removed := range c.Removed {
-			if removed.Type == treeType {
-				continue
-			}
-			r = append(r, *removed)
-		}
-		if len(r) > 0 {
+	resp, err := t.do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
 
-			fmt.Println("Removidos:")
-			for _, removed := range r {
-				fmt.Println(removed.Path)
-			}
-		}
+	if resp.StatusCode != http.StatusOK {
+		return nil, newHTTPError("baixar a árvore", resp)
 	}
 
-	return &c, nil
+	var n Node
+	err = json.NewDecoder(resp.Body).Decode(&n)
+	if err != nil {
+		return nil, err
+	}
+	return &n, nil
+}
+
+// sendFilesToServer envia os arquivos alterados para o servidor. O zip é
+// gravado em .tinygit/tmp e enviado em partes simultâneas, retomando de onde
+// parou em caso de falha. Servidores sem suporte a upload em partes recebem o zip
+// em uma única requisição.
+func sendFilesToServer(c Changes, rootPath string, serverUrl string, parameters map[string]string, t *transfer) error {
+	tmpDir := filepath.Join(rootPath, versionDirName, tmpDirName)
+	err := os.MkdirAll(tmpDir, 0700)
+	if err != nil {
+		return err
+	}
+
+	zipPath := filepath.Join(tmpDir, "push.zip")
+	zipFile, err := os.Create(zipPath)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(zipPath)
+
+	sigs := requestDeltaSignatures(c, rootPath, serverUrl, parameters, t)
+
+	err = writeFilesZip(t.ctx, zipFile, c, rootPath, sigs, t.progress)
+	if err != nil {
+		zipFile.Close()
+		return err
+	}
+	err = zipFile.Close()
+	if err != nil {
+		return err
+	}
+
+	err = uploadInChunks(rootPath, zipPath, serverUrl, parameters, t)
+	if errors.Is(err, errChunkedUploadUnsupported) {
+		logger().Info("Servidor não suporta upload em partes, enviando arquivo completo")
+		err = streamFilesToServer(c, rootPath, serverUrl, parameters, sigs, t)
+	}
+
+	// Sem as renomeações, os arquivos renomeados são enviados como
+	// adicionados
+	if errors.Is(err, errPushRename) && len(c.Renamed) > 0 {
+		logger().Warn("Servidor não aplicou as renomeações, enviando o conteúdo dos arquivos", "erro", err)
+		c.foldRenames()
+		return sendFilesToServer(c, rootPath, serverUrl, parameters, t)
+	}
+	return err
 }
 
-// sendFilesToServer envia o arquivo compactado para o servidor
-func sendFilesToServer(b []bytes.Buffer, serverUrl string, parameters map[string]string) error {
+// streamFilesToServer envia os arquivos em uma única requisição. O zip é
+// gerado enquanto é enviado, sem ser mantido em memória.
+func streamFilesToServer(c Changes, rootPath string, serverUrl string, parameters map[string]string, sigs map[string]*fileSignature, t *transfer) error {
 	u, err := parseUrlParameter(serverUrl, "push", parameters)
 
 	if err != nil {
 		return err
 	}
 
-	req, err := http.NewRequest(http.MethodPost, u, &b[0])
+	pr, pw := io.Pipe()
+	defer pr.Close()
+
+	go func() {
+		pw.CloseWithError(writeFilesZip(t.ctx, pw, c, rootPath, sigs, t.progress))
+	}()
+
+	req, err := http.NewRequest(http.MethodPost, u, t.reader(pr))
 	if err != nil {
 		return err
 	}
+	req.Header.Set("Content-Type", "application/zip")
 
-	client := http.Client{}
-	resp, err := client.Do(req)
+	resp, err := t.do(req)
 	if err != nil {
 		return err
 	}
+	defer resp.Body.Close()
 
+	if pushRenameRejected(resp) {
+		return fmt.Errorf("%w: %v", errPushRename, newHTTPError("enviar arquivos", resp))
+	}
 	if resp.StatusCode != http.StatusOK {
-		return errors.New("erro ao enviar arquivos")
+		return newHTTPError("enviar arquivos", resp)
 	}
 
 	return nil
 }
 
-func parseUrlParameter(serverUrl, path string, parameters map[string]string) (string, error) {
+func parseUrlParameter(serverUrl, endpoint string, parameters map[string]string) (string, error) {
 	u, err := url.Parse(serverUrl)
 	if err != nil {
 		return "", err
 	}
-	u.Path = filepath.Join(u.Path, path)
+	// Caminhos de URL sempre usam /, inclusive no Windows
+	u.Path = path.Join(u.Path, endpoint)
 	q := u.Query()
 	for k, v := range parameters {
 		q.Add(k, v)
@@ -331,10 +465,9 @@ func unzipFiles(zipPath string, destPath string) error {
 	defer r.Close()
 
 	for _, f := range r.File {
-		fpath := filepath.Join(destPath, f.Name)
-
-		if !strings.HasPrefix(fpath, filepath.Clean(destPath)+string(os.PathSeparator)) {
-			return fmt.Errorf("%s: invalid file path", fpath)
+		fpath, err := safeJoin(destPath, f.Name)
+		if err != nil {
+			return err
 		}
 
 		if f.FileInfo().IsDir() {
@@ -342,44 +475,64 @@ func unzipFiles(zipPath string, destPath string) error {
 			continue
 		}
 
-		if err = os.MkdirAll(filepath.Dir(fpath), os.ModePerm); err != nil {
-			return err
-		}
-
-		destFile, err := os.OpenFile(fpath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, f.Mode())
+		err = extractZipFile(f, fpath)
 		if err != nil {
 			return err
 		}
+	}
 
-		fileInArchive, err := f.Open()
-		if err != nil {
-			return err
-		}
+	return nil
+}
 
-		_, err = io.Copy(destFile, fileInArchive)
+// Junta o nome de uma entrada do zip ao diretório de destino, impedindo que
+// a entrada escape do diretório
+func safeJoin(destPath, name string) (string, error) {
+	fpath := filepath.Join(destPath, name)
 
-		if err != nil {
-			return err
-		}
+	if !strings.HasPrefix(fpath, filepath.Clean(destPath)+string(os.PathSeparator)) {
+		return "", fmt.Errorf("%s: invalid file path", fpath)
+	}
 
-		err = destFile.Close()
+	return fpath, nil
+}
 
-		if err != nil {
-			return err
-		}
+// Extrai uma entrada do zip para fpath, preservando a data de modificação
+func extractZipFile(f *zip.File, fpath string) error {
+	if err := os.MkdirAll(filepath.Dir(fpath), os.ModePerm); err != nil {
+		return err
+	}
 
-		err = fileInArchive.Close()
+	destFile, err := os.OpenFile(fpath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, f.Mode())
+	if err != nil {
+		return err
+	}
 
-		if err != nil {
-			return err
-		}
+	fileInArchive, err := f.Open()
+	if err != nil {
+		destFile.Close()
+		return err
+	}
 
-		err = os.Chtimes(fpath, f.Modified, f.Modified)
-		if err != nil {
-			return err
-		}
+	_, err = io.Copy(destFile, fileInArchive)
 
+	if err != nil {
+		destFile.Close()
+		fileInArchive.Close()
+		return err
 	}
 
-	return nil
+	err = destFile.Close()
+
+	if err != nil {
+		fileInArchive.Close()
+		return err
+	}
+
+	err = fileInArchive.Close()
+
+	if err != nil {
+		return err
+	}
+
+	return os.Chtimes(fpath, f.Modified, f.Modified)
 }
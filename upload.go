@@ -0,0 +1,614 @@
+package tinygit
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	tmpDirName         = "tmp"
+	uploadsDirName     = "uploads"
+	uploadDataName     = "data"
+	uploadSessionName  = "session.json"
+	uploadSessionTTL   = 7 * 24 * time.Hour
+	uploadChunkSize    = 8 << 20
+	uploadChunkRetries = 5
+)
+
+// Protege a leitura e gravação dos arquivos de sessão de upload
+var uploadMu sync.Mutex
+
+// Sessões sendo finalizadas. Finalizações simultâneas da mesma sessão são
+// executadas uma de cada vez, para que os arquivos não sejam descompactados
+// duas vezes.
+var (
+	finalizeMu    sync.Mutex
+	finalizeLocks = map[string]*sessionLock{}
+)
+
+type sessionLock struct {
+	mu   sync.Mutex
+	refs int
+}
+
+// Bloqueia a finalização da sessão e retorna a função que a libera
+func lockFinalize(id string) func() {
+	finalizeMu.Lock()
+	l, found := finalizeLocks[id]
+	if !found {
+		l = &sessionLock{}
+		finalizeLocks[id] = l
+	}
+	l.refs++
+	finalizeMu.Unlock()
+
+	l.mu.Lock()
+	return func() {
+		l.mu.Unlock()
+		finalizeMu.Lock()
+		l.refs--
+		if l.refs == 0 {
+			delete(finalizeLocks, id)
+		}
+		finalizeMu.Unlock()
+	}
+}
+
+// UploadSession representa um upload em partes de um push
+type UploadSession struct {
+	ID       string     `json:"id"`
+	Size     int64      `json:"size"`
+	Hash     string     `json:"hash"`
+	Received [][2]int64 `json:"received"`
+	Created  time.Time  `json:"created"`
+}
+
+// Verifica se todos os bytes do upload foram recebidos
+func (s *UploadSession) complete() bool {
+	return s.Size == 0 || (len(s.Received) == 1 && s.Received[0][0] == 0 && s.Received[0][1] == s.Size)
+}
+
+// Retorna os intervalos [início, fim) ainda não recebidos
+func (s *UploadSession) missing() [][2]int64 {
+	var missing [][2]int64
+	var offset int64
+	for _, r := range s.Received {
+		if r[0] > offset {
+			missing = append(missing, [2]int64{offset, r[0]})
+		}
+		offset = r[1]
+	}
+	if offset < s.Size {
+		missing = append(missing, [2]int64{offset, s.Size})
+	}
+	return missing
+}
+
+// Adiciona um intervalo recebido, mantendo a lista ordenada e sem sobreposições
+func (s *UploadSession) addRange(start, end int64) {
+	ranges := append(s.Received, [2]int64{start, end})
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i][0] < ranges[j][0] })
+
+	merged := [][2]int64{}
+	for _, r := range ranges {
+		last := len(merged) - 1
+		if last >= 0 && r[0] <= merged[last][1] {
+			if r[1] > merged[last][1] {
+				merged[last][1] = r[1]
+			}
+			continue
+		}
+		merged = append(merged, r)
+	}
+	s.Received = merged
+}
+
+func uploadDir(rootPath, id string) (string, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return "", errors.New("identificador de upload inválido")
+	}
+	return filepath.Join(rootPath, versionDirName, uploadsDirName, id), nil
+}
+
+func readUploadSession(rootPath, id string) (*UploadSession, error) {
+	dir, err := uploadDir(rootPath, id)
+	if err != nil {
+		return nil, err
+	}
+
+	raw, err := os.ReadFile(filepath.Join(dir, uploadSessionName))
+	if err != nil {
+		return nil, err
+	}
+
+	var s UploadSession
+	err = json.Unmarshal(raw, &s)
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+func writeUploadSession(rootPath string, s *UploadSession) error {
+	dir, err := uploadDir(rootPath, s.ID)
+	if err != nil {
+		return err
+	}
+
+	b, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, uploadSessionName), b, 0600)
+}
+
+// Remove sessões de upload abandonadas
+func removeExpiredUploads(rootPath string) {
+	dir := filepath.Join(rootPath, versionDirName, uploadsDirName)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		info, err := os.Stat(filepath.Join(dir, entry.Name(), uploadSessionName))
+		if err == nil && time.Since(info.ModTime()) < uploadSessionTTL {
+			continue
+		}
+		os.RemoveAll(filepath.Join(dir, entry.Name()))
+	}
+}
+
+func writeUploadSessionResponse(w http.ResponseWriter, s *UploadSession) {
+	b, err := json.Marshal(s)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(b)
+}
+
+// CreateUploadHandler cria uma sessão de upload em partes. O corpo da
+// requisição informa o tamanho e o hash do zip que será enviado.
+func CreateUploadHandler(w http.ResponseWriter, r *http.Request, rootPath string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Método não permitido", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req UploadSession
+	err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&req)
+	if err != nil || req.Size < 0 {
+		http.Error(w, "Requisição inválida", http.StatusBadRequest)
+		return
+	}
+	if req.Size > MaxPushSize {
+		http.Error(w, "Arquivo excede o tamanho máximo permitido", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	removeExpiredUploads(rootPath)
+
+	s := &UploadSession{
+		ID:       uuid.New().String(),
+		Size:     req.Size,
+		Hash:     req.Hash,
+		Received: [][2]int64{},
+		Created:  time.Now(),
+	}
+
+	dir, _ := uploadDir(rootPath, s.ID)
+	err = os.MkdirAll(dir, 0700)
+	if err == nil {
+		var data *os.File
+		data, err = os.Create(filepath.Join(dir, uploadDataName))
+		if err == nil {
+			err = data.Close()
+		}
+	}
+	if err == nil {
+		err = writeUploadSession(rootPath, s)
+	}
+	if err != nil {
+		http.Error(w, "Erro ao criar sessão de upload", http.StatusInternalServerError)
+		return
+	}
+
+	writeUploadSessionResponse(w, s)
+}
+
+// UploadChunkHandler grava uma parte do upload na posição indicada pelo
+// parâmetro offset
+func UploadChunkHandler(w http.ResponseWriter, r *http.Request, rootPath string) {
+	if r.Method != http.MethodPut {
+		http.Error(w, "Método não permitido", http.StatusMethodNotAllowed)
+		return
+	}
+	defer r.Body.Close()
+
+	id := r.URL.Query().Get("id")
+	offset, err := strconv.ParseInt(r.URL.Query().Get("offset"), 10, 64)
+	if err != nil || offset < 0 {
+		http.Error(w, "Offset inválido", http.StatusBadRequest)
+		return
+	}
+
+	uploadMu.Lock()
+	s, err := readUploadSession(rootPath, id)
+	uploadMu.Unlock()
+	if err != nil {
+		http.Error(w, "Sessão de upload não encontrada", http.StatusNotFound)
+		return
+	}
+	if offset > s.Size {
+		http.Error(w, "Offset inválido", http.StatusBadRequest)
+		return
+	}
+
+	dir, _ := uploadDir(rootPath, id)
+	data, err := os.OpenFile(filepath.Join(dir, uploadDataName), os.O_WRONLY, 0600)
+	if err != nil {
+		http.Error(w, "Erro ao abrir o upload", http.StatusInternalServerError)
+		return
+	}
+
+	// Grava apenas o que foi recebido; uma parte interrompida é registrada
+	// até onde chegou para que o cliente continue dali
+	limit := s.Size - offset
+	n, copyErr := io.Copy(io.NewOffsetWriter(data, offset), http.MaxBytesReader(w, r.Body, limit))
+	closeErr := data.Close()
+
+	if n > 0 && closeErr == nil {
+		uploadMu.Lock()
+		s, err = readUploadSession(rootPath, id)
+		if err == nil {
+			s.addRange(offset, offset+n)
+			err = writeUploadSession(rootPath, s)
+		}
+		uploadMu.Unlock()
+		if err != nil {
+			http.Error(w, "Erro ao atualizar sessão de upload", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if copyErr != nil || closeErr != nil {
+		http.Error(w, "Erro ao gravar parte do upload", http.StatusInternalServerError)
+		return
+	}
+
+	writeUploadSessionResponse(w, s)
+}
+
+// UploadStatusHandler informa os intervalos já recebidos de um upload
+func UploadStatusHandler(w http.ResponseWriter, r *http.Request, rootPath string) {
+	uploadMu.Lock()
+	s, err := readUploadSession(rootPath, r.URL.Query().Get("id"))
+	uploadMu.Unlock()
+	if err != nil {
+		http.Error(w, "Sessão de upload não encontrada", http.StatusNotFound)
+		return
+	}
+
+	writeUploadSessionResponse(w, s)
+}
+
+// FinalizeUploadHandler verifica o upload completo e descompacta os arquivos,
+// assim como PushFilesHandler
+func FinalizeUploadHandler(w http.ResponseWriter, r *http.Request, rootPath string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Método não permitido", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id := r.URL.Query().Get("id")
+	unlock := lockFinalize(id)
+	defer unlock()
+
+	// Após uma finalização concorrente, a sessão já foi removida
+	uploadMu.Lock()
+	s, err := readUploadSession(rootPath, id)
+	uploadMu.Unlock()
+	if err != nil {
+		http.Error(w, "Sessão de upload não encontrada", http.StatusNotFound)
+		return
+	}
+
+	if !s.complete() {
+		http.Error(w, "Upload incompleto", http.StatusConflict)
+		return
+	}
+
+	dir, _ := uploadDir(rootPath, id)
+	dataPath := filepath.Join(dir, uploadDataName)
+
+	if s.Hash != "" {
+		hash, err := calculateContentHash(dataPath)
+		if err != nil {
+			http.Error(w, "Erro ao verificar o upload", http.StatusInternalServerError)
+			return
+		}
+		if !CompareHashes(hash, s.Hash) {
+			os.RemoveAll(dir)
+			http.Error(w, "Upload corrompido", http.StatusUnprocessableEntity)
+			return
+		}
+	}
+
+	err = unzipPushFiles(dataPath, rootPath)
+	if err != nil {
+		// O zip não pode ser aplicado: o cliente envia outro
+		if errors.Is(err, errPushRename) {
+			os.RemoveAll(dir)
+		}
+		writePushError(w, err)
+		return
+	}
+
+	os.RemoveAll(dir)
+	w.WriteHeader(http.StatusOK)
+}
+
+// Estado local de um push em partes, usado para retomar o envio
+type pushState struct {
+	ID   string `json:"id"`
+	Hash string `json:"hash"`
+}
+
+var errChunkedUploadUnsupported = errors.New("servidor não suporta upload em partes")
+
+// Envia o zip do push em partes, com até t.jobs partes simultâneas,
+// retomando uma sessão anterior quando o mesmo conteúdo já começou a ser
+// enviado
+func uploadInChunks(rootPath, zipPath, serverUrl string, parameters map[string]string, t *transfer) error {
+	hash, err := calculateContentHash(zipPath)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(zipPath)
+	if err != nil {
+		return err
+	}
+
+	statePath := filepath.Join(rootPath, versionDirName, tmpDirName, "push.json")
+	var s *UploadSession
+
+	var state pushState
+	if raw, err := os.ReadFile(statePath); err == nil && json.Unmarshal(raw, &state) == nil && state.Hash == hash {
+		s, err = requestUploadSession(uploadStatus, state.ID, serverUrl, parameters, nil, t)
+		if err == nil {
+			logger().Info("Retomando envio anterior")
+		}
+	}
+
+	if s == nil {
+		body, err := json.Marshal(UploadSession{Size: info.Size(), Hash: hash})
+		if err != nil {
+			return err
+		}
+		s, err = requestUploadSession(uploadCreate, "", serverUrl, parameters, body, t)
+		if err != nil {
+			return err
+		}
+
+		b, err := json.Marshal(pushState{ID: s.ID, Hash: hash})
+		if err != nil {
+			return err
+		}
+		err = os.WriteFile(statePath, b, 0600)
+		if err != nil {
+			return err
+		}
+	}
+
+	file, err := os.Open(zipPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	t.progress.start(PhaseUpload, s.Size, 0)
+	t.progress.setBytes(s.Size - missingBytes(s))
+
+	for !s.complete() {
+		var chunks [][2]int64
+		for _, r := range s.missing() {
+			for start := r[0]; start < r[1]; start += uploadChunkSize {
+				end := start + uploadChunkSize
+				if end > r[1] {
+					end = r[1]
+				}
+				chunks = append(chunks, [2]int64{start, end})
+			}
+		}
+
+		id := s.ID
+		missing := missingBytes(s)
+		err = t.run(len(chunks), func(i int) error {
+			return sendChunkWithRetries(file, id, chunks[i][0], chunks[i][1], serverUrl, parameters, t)
+		})
+		if err != nil {
+			return fmt.Errorf("erro ao enviar parte do upload: %w", err)
+		}
+
+		// Confirma o que o servidor recebeu antes de finalizar
+		s, err = requestUploadSession(uploadStatus, id, serverUrl, parameters, nil, t)
+		if err != nil {
+			return err
+		}
+
+		// Uma rodada sem progresso se repetiria indefinidamente
+		if !s.complete() && missingBytes(s) >= missing {
+			return fmt.Errorf("erro ao enviar o upload: o servidor não registrou as partes enviadas (%d bytes pendentes)", missingBytes(s))
+		}
+	}
+
+	_, err = requestUploadSession(uploadFinalize, s.ID, serverUrl, parameters, nil, t)
+	if err != nil {
+		return err
+	}
+	t.progress.finish()
+
+	os.Remove(statePath)
+	return nil
+}
+
+func missingBytes(s *UploadSession) int64 {
+	var n int64
+	for _, r := range s.missing() {
+		n += r[1] - r[0]
+	}
+	return n
+}
+
+// Envia uma parte, tentando novamente em caso de falha
+func sendChunkWithRetries(file *os.File, id string, start, end int64, serverUrl string, parameters map[string]string, t *transfer) error {
+	failures := 0
+	for {
+		s, err := sendChunk(file, id, start, end, serverUrl, parameters, t)
+		if err == nil {
+			logger().Debug("Parte enviada", "bytes", s.Size-missingBytes(s), "total", s.Size)
+			t.progress.add(end-start, 0)
+			return nil
+		}
+		if t.ctx.Err() != nil {
+			return t.ctx.Err()
+		}
+		failures++
+		if failures > uploadChunkRetries {
+			return err
+		}
+		logger().Warn("Erro ao enviar parte do upload, tentando novamente", "erro", err)
+		if err := t.sleep(time.Duration(failures) * time.Second); err != nil {
+			return err
+		}
+	}
+}
+
+// Envia os bytes [start, end) do arquivo
+func sendChunk(file *os.File, id string, start, end int64, serverUrl string, parameters map[string]string, t *transfer) (*UploadSession, error) {
+	u, err := parseUrlParameter(serverUrl, "upload/chunk", withParameters(parameters, "id", id, "offset", strconv.FormatInt(start, 10)))
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(http.MethodPut, u, t.reader(io.NewSectionReader(file, start, end-start)))
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = end - start
+	req.Header.Set("Content-Type", "application/octet-stream")
+
+	resp, err := t.do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, newHTTPError("enviar parte do upload", resp)
+	}
+
+	var s UploadSession
+	err = json.NewDecoder(resp.Body).Decode(&s)
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// Operações sobre uma sessão de upload
+type uploadOp int
+
+const (
+	// Cria a sessão; servidores antigos respondem com 404 ou 405
+	uploadCreate uploadOp = iota
+	// Consulta as partes recebidas
+	uploadStatus
+	// Descompacta o upload completo; não retorna a sessão
+	uploadFinalize
+)
+
+func requestUploadSession(op uploadOp, id, serverUrl string, parameters map[string]string, body []byte, t *transfer) (*UploadSession, error) {
+	method, endpoint, desc := http.MethodPost, "upload", "criar a sessão de upload"
+	switch op {
+	case uploadStatus:
+		method, endpoint, desc = http.MethodGet, "upload/status", "consultar a sessão de upload"
+	case uploadFinalize:
+		endpoint, desc = "upload/finalize", "finalizar o upload"
+	}
+
+	params := parameters
+	if id != "" {
+		params = withParameters(parameters, "id", id)
+	}
+	u, err := parseUrlParameter(serverUrl, endpoint, params)
+	if err != nil {
+		return nil, err
+	}
+
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+
+	req, err := http.NewRequest(method, u, reader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := t.do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if op == uploadCreate && (resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusMethodNotAllowed) {
+		return nil, errChunkedUploadUnsupported
+	}
+	if op == uploadFinalize && pushRenameRejected(resp) {
+		return nil, fmt.Errorf("%w: %v", errPushRename, newHTTPError(desc, resp))
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, newHTTPError(desc, resp)
+	}
+
+	if op == uploadFinalize {
+		return nil, nil
+	}
+
+	var s UploadSession
+	err = json.NewDecoder(resp.Body).Decode(&s)
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// Retorna uma cópia dos parâmetros com os pares chave/valor adicionados
+func withParameters(parameters map[string]string, kv ...string) map[string]string {
+	params := make(map[string]string, len(parameters)+len(kv)/2)
+	for k, v := range parameters {
+		params[k] = v
+	}
+	for i := 0; i+1 < len(kv); i += 2 {
+		params[kv[i]] = kv[i+1]
+	}
+	return params
+}
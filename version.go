@@ -1,18 +1,61 @@
 package tinygit
 
 import (
+	"context"
 	"crypto/sha1"
 	"encoding/hex"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"time"
 )
 
+// Constrói a árvore a partir de path, informando os arquivos processados
+type treeBuilder struct {
+	ctx      context.Context
+	rootPath string
+	ext      *[]string
+	ignore   *[]string
+	progress *progress
+
+	// Arquivos com extensão não versionada e entradas ignoradas pelo nome
+	untracked int
+	ignored   int
+}
+
 // Constrói recursivamente a árvore
 func buildTree(rootPath, path string, ext, ignore *[]string) (*Node, error) {
+	b := &treeBuilder{ctx: context.Background(), rootPath: rootPath, ext: ext, ignore: ignore}
+	return b.build(path)
+}
+
+// Constrói a árvore de todo o diretório informando o progresso em p. O
+// percurso é interrompido se ctx for cancelado.
+func buildTreeWithProgress(ctx context.Context, rootPath string, ext, ignore *[]string, p *progress) (*Node, error) {
+	b := &treeBuilder{ctx: ctx, rootPath: rootPath, ext: ext, ignore: ignore, progress: p}
+	return b.buildRoot()
+}
+
+// Constrói a árvore de todo o diretório, informando o progresso
+func (b *treeBuilder) buildRoot() (*Node, error) {
+	b.progress.start(PhaseScan, 0, 0)
+	node, err := b.build(b.rootPath)
+	if err != nil {
+		return nil, err
+	}
+	b.progress.finish()
+	return node, nil
+}
+
+func (b *treeBuilder) build(path string) (*Node, error) {
+	if err := b.ctx.Err(); err != nil {
+		return nil, err
+	}
+	rootPath := b.rootPath
+
 	// Calcula o caminho relativo em relação ao diretório base
 	relativePath, err := filepath.Rel(rootPath, path)
 	if err != nil {
@@ -30,7 +73,7 @@ func buildTree(rootPath, path string, ext, ignore *[]string) (*Node, error) {
 
 	if fileInfo.IsDir() {
 		node.Type = treeType
-		children, err := readDir(rootPath, path, ext, ignore)
+		children, err := b.readDir(path)
 		if err != nil {
 			return nil, err
 		}
@@ -39,19 +82,23 @@ func buildTree(rootPath, path string, ext, ignore *[]string) (*Node, error) {
 		}
 		node.Children = children
 
-		// Calcula o hash do diretório combinando os hashes dos filhos
+		// Calcula o hash do diretório combinando os nomes e os hashes dos
+		// filhos
 		dirHash := sha1.New()
 		for _, child := range children {
-			io.WriteString(dirHash, child.Hash)
+			writeChildHash(dirHash, child)
+			node.Size += child.Size
 		}
 		node.Hash = hex.EncodeToString(dirHash.Sum(nil))
 
 	} else {
 		node.Type = blobType
-		node.Hash, err = calculateFileHash(path)
+		node.Size = fileInfo.Size()
+		node.Hash, node.ContentHash, err = calculateFileHashesContext(b.ctx, path)
 		if err != nil {
 			return nil, err
 		}
+		b.progress.add(fileInfo.Size(), 1)
 	}
 
 	return node, nil
@@ -59,23 +106,32 @@ func buildTree(rootPath, path string, ext, ignore *[]string) (*Node, error) {
 
 // Calcula o hash para um arquivo, incluindo seus metadados
 func calculateFileHash(filePath string) (string, error) {
+	hash, _, err := calculateFileHashes(filePath)
+	return hash, err
+}
+
+// Calcula o hash com metadados e o hash apenas do conteúdo de um arquivo
+func calculateFileHashes(filePath string) (string, string, error) {
+	return calculateFileHashesContext(context.Background(), filePath)
+}
+
+func calculateFileHashesContext(ctx context.Context, filePath string) (string, string, error) {
 	file, err := os.Open(filePath)
 	if err != nil {
-		return "", err
+		return "", "", err
 	}
 	defer file.Close()
 
 	fileInfo, err := file.Stat()
 	if err != nil {
-		return "", err
+		return "", "", err
 	}
 
 	// Lê o conteúdo do arquivo
-	fileHash := sha1.New()
-	if _, err := io.Copy(fileHash, file); err != nil {
-		return "", err
+	contentHash, err := hashContent(readerWithContext(ctx, file))
+	if err != nil {
+		return "", "", err
 	}
-	contentHash := fileHash.Sum(nil)
 
 	// Inclui metadados do arquivo no hash
 	metaHash := sha1.New()
@@ -83,31 +139,74 @@ func calculateFileHash(filePath string) (string, error) {
 	metaHash.Write([]byte(metaData))
 	metaHash.Write(contentHash)
 
-	return hex.EncodeToString(metaHash.Sum(nil)), nil
+	return hex.EncodeToString(metaHash.Sum(nil)), hex.EncodeToString(contentHash), nil
 }
 
+// Calcula o hash apenas do conteúdo de um arquivo, sem metadados
+func calculateContentHash(filePath string) (string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	contentHash, err := hashContent(file)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(contentHash), nil
+}
+
+func hashContent(r io.Reader) ([]byte, error) {
+	fileHash := sha1.New()
+	if _, err := io.Copy(fileHash, r); err != nil {
+		return nil, err
+	}
+	return fileHash.Sum(nil), nil
+}
+
+// CompareTrees retorna os arquivos alterados da árvore salva para a atual,
+// ordenados pelo caminho. Diretórios adicionados ou removidos são listados
+// pelos seus arquivos. Arquivos removidos e adicionados com o mesmo conteúdo
+// são informados em Renamed.
 func CompareTrees(savedNode, currentNode *Node) *Changes {
+	changes := compareTrees(savedNode, currentNode)
+	changes.detectRenames()
+	changes.sort()
+	return changes
+}
+
+func compareTrees(savedNode, currentNode *Node) *Changes {
 	changes := &Changes{}
 
-	if (savedNode == nil || savedNode.Hash == "") && currentNode == nil {
-		return changes
+	// A árvore de um diretório vazio não tem hash
+	if savedNode != nil && savedNode.Hash == "" {
+		savedNode = nil
 	}
-	if savedNode == nil {
-		changes.Added = append(changes.Added, currentNode)
-		return changes
+	if currentNode != nil && currentNode.Hash == "" {
+		currentNode = nil
 	}
 
-	if currentNode == nil {
-		changes.Removed = append(changes.Removed, savedNode)
+	switch {
+	case savedNode == nil && currentNode == nil:
 		return changes
-	}
-
-	// Se os hashes são diferentes, marcamos o nó como modificado
-	if savedNode.Hash != currentNode.Hash {
-		changes.Modified = append(changes.Modified, currentNode)
-	} else {
+	case savedNode == nil:
+		changes.Added = appendBlobs(changes.Added, currentNode)
+		return changes
+	case currentNode == nil:
+		changes.Removed = appendBlobs(changes.Removed, savedNode)
+		return changes
+	case savedNode.Hash == currentNode.Hash:
 		// Se os hashes são iguais, não precisamos comparar os filhos
 		return changes
+	case savedNode.Type != currentNode.Type:
+		// Arquivo substituído por um diretório, ou o contrário
+		changes.Removed = appendBlobs(changes.Removed, savedNode)
+		changes.Added = appendBlobs(changes.Added, currentNode)
+		return changes
+	case currentNode.Type != treeType:
+		changes.Modified = append(changes.Modified, currentNode)
+		return changes
 	}
 
 	// Mapear os filhos por caminho para comparação
@@ -122,27 +221,153 @@ func CompareTrees(savedNode, currentNode *Node) *Changes {
 	}
 
 	// Verifica por nós adicionados e modificados
-	for path, currentChild := range currentChildrenMap {
-		if savedChild, found := savedChildrenMap[path]; found {
-			childChanges := CompareTrees(savedChild, currentChild)
-			changes.Added = append(changes.Added, childChanges.Added...)
-			changes.Removed = append(changes.Removed, childChanges.Removed...)
-			changes.Modified = append(changes.Modified, childChanges.Modified...)
-		} else {
-			changes.Added = append(changes.Added, currentChild)
-		}
+	for _, currentChild := range currentNode.Children {
+		childChanges := compareTrees(savedChildrenMap[currentChild.Path], currentChild)
+		changes.Added = append(changes.Added, childChanges.Added...)
+		changes.Removed = append(changes.Removed, childChanges.Removed...)
+		changes.Modified = append(changes.Modified, childChanges.Modified...)
 	}
 
 	// Verifica por nós removidos
-	for path, savedChild := range savedChildrenMap {
-		if _, found := currentChildrenMap[path]; !found {
-			changes.Removed = append(changes.Removed, savedChild)
+	for _, savedChild := range savedNode.Children {
+		if _, found := currentChildrenMap[savedChild.Path]; !found {
+			changes.Removed = appendBlobs(changes.Removed, savedChild)
 		}
 	}
 
 	return changes
 }
 
+// Ordena as alterações pelo caminho, tornando o resultado determinístico
+func (c *Changes) sort() {
+	for _, nodes := range []*[]*Node{&c.Added, &c.Removed, &c.Modified} {
+		if *nodes == nil {
+			*nodes = []*Node{}
+		}
+		list := *nodes
+		sort.Slice(list, func(i, j int) bool { return list[i].Path < list[j].Path })
+	}
+	sort.Slice(c.Renamed, func(i, j int) bool { return c.Renamed[i].NewPath < c.Renamed[j].NewPath })
+}
+
+// Monta a árvore de um commit parcial: os arquivos nos caminhos selecionados
+// vêm da árvore atual e os demais da árvore salva. Retorna nil se a árvore
+// resultante estiver vazia.
+func selectTree(saved, current *Node, selected []string) (*Node, error) {
+	savedFiles := map[string]*Node{}
+	collectBlobs(saved, savedFiles)
+	currentFiles := map[string]*Node{}
+	collectBlobs(current, currentFiles)
+
+	isSelected := func(path string) bool {
+		for _, dir := range selected {
+			if dir == "." || isUnder(path, dir) {
+				return true
+			}
+		}
+		return false
+	}
+
+	for _, dir := range selected {
+		found := dir == "."
+		for _, files := range []map[string]*Node{savedFiles, currentFiles} {
+			for path := range files {
+				if isUnder(path, dir) {
+					found = true
+					break
+				}
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("%w: %s", ErrNotFound, dir)
+		}
+	}
+
+	files := map[string]*Node{}
+	for path, node := range savedFiles {
+		if !isSelected(path) {
+			files[path] = node
+		}
+	}
+	for path, node := range currentFiles {
+		if isSelected(path) {
+			files[path] = node
+		}
+	}
+	return treeFromBlobs(files), nil
+}
+
+// Monta uma árvore a partir dos arquivos, com os hashes dos diretórios
+// calculados como em buildTree. Retorna nil se não houver arquivos.
+func treeFromBlobs(files map[string]*Node) *Node {
+	if len(files) == 0 {
+		return nil
+	}
+
+	root := &Node{Path: ".", Type: treeType}
+	dirs := map[string]*Node{".": root}
+	var dirNode func(path string) *Node
+	dirNode = func(path string) *Node {
+		if dir, found := dirs[path]; found {
+			return dir
+		}
+		dir := &Node{Path: path, Type: treeType}
+		dirs[path] = dir
+		parent := dirNode(filepath.Dir(path))
+		parent.Children = append(parent.Children, dir)
+		return dir
+	}
+
+	for path, node := range files {
+		blob := *node
+		parent := dirNode(filepath.Dir(path))
+		parent.Children = append(parent.Children, &blob)
+	}
+
+	hashTree(root)
+	return root
+}
+
+// Ordena os filhos pelo nome, como os.ReadDir, e calcula o hash e o tamanho
+// dos diretórios
+func hashTree(node *Node) {
+	sort.Slice(node.Children, func(i, j int) bool {
+		return filepath.Base(node.Children[i].Path) < filepath.Base(node.Children[j].Path)
+	})
+
+	dirHash := sha1.New()
+	node.Size = 0
+	for _, child := range node.Children {
+		if child.Type == treeType {
+			hashTree(child)
+		}
+		writeChildHash(dirHash, child)
+		node.Size += child.Size
+	}
+	node.Hash = hex.EncodeToString(dirHash.Sum(nil))
+}
+
+// Escreve o nome e o hash do filho no hash do diretório. Com o nome, renomear
+// um arquivo sem alterá-lo também muda o hash dos diretórios acima dele.
+func writeChildHash(w io.Writer, child *Node) {
+	io.WriteString(w, filepath.Base(child.Path)+"\x00"+child.Hash)
+}
+
+// Versão do cálculo do hash dos diretórios, gravada com as árvores salvas.
+// Na versão 0 o hash combinava apenas os hashes dos filhos.
+const treeHashVersion = 1
+
+// Recalcula os hashes dos diretórios de uma árvore gravada em uma versão
+// anterior do cálculo. Os hashes dos arquivos não mudam. Retorna se a árvore
+// foi alterada.
+func upgradeTreeHash(tree *Node, version int) bool {
+	if version >= treeHashVersion || tree.Hash == "" || tree.Type != treeType {
+		return false
+	}
+	hashTree(tree)
+	return true
+}
+
 func CompareHashes(hash1, hash2 string) bool {
 	return strings.EqualFold(hash1, hash2)
 }
@@ -151,7 +376,7 @@ func printTree(node *Node) {
 
 	printFile, err := os.OpenFile("tree.txt", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 	if err != nil {
-		fmt.Println("Erro ao abrir o arquivo:", err)
+		logger().Error("Erro ao abrir o arquivo", "erro", err)
 		return
 	}
 	defer printFile.Close()
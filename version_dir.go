@@ -3,7 +3,6 @@
 package tinygit
 
 import (
-	"fmt"
 	"os"
 	"path/filepath"
 )
@@ -14,7 +13,7 @@ func generateVersionDir(rootPath string) error {
 	if _, err := os.Stat(dirVerision); os.IsNotExist(err) {
 		err := os.MkdirAll(dirVerision, 0700)
 		if err != nil {
-			fmt.Println("Erro ao criar diretório de versão:", err)
+			logger().Error("Erro ao criar diretório de versão", "erro", err)
 			return err
 		}
 	}
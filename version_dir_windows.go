@@ -3,7 +3,6 @@
 package tinygit
 
 import (
-	"fmt"
 	"os"
 	"path/filepath"
 	"syscall"
@@ -15,18 +14,18 @@ func generateVersionDir(rootPath string) error {
 	if _, err := os.Stat(dirVerision); os.IsNotExist(err) {
 		err := os.MkdirAll(dirVerision, 0700)
 		if err != nil {
-			fmt.Println("Erro ao criar diretório de versão:", err)
+			logger().Error("Erro ao criar diretório de versão", "erro", err)
 			return err
 		}
 
 		dirVerisionPtr, err := syscall.UTF16PtrFromString(dirVerision)
 		if err != nil {
-			fmt.Println("Erro ao converter o caminho para UTF16:", err)
+			logger().Error("Erro ao converter o caminho para UTF16", "erro", err)
 			return err
 		}
 		err = syscall.SetFileAttributes(dirVerisionPtr, syscall.FILE_ATTRIBUTE_HIDDEN)
 		if err != nil {
-			fmt.Println("Erro ao ocultar o diretório de versão:", err)
+			logger().Error("Erro ao ocultar o diretório de versão", "erro", err)
 			return err
 		}
 	}
@@ -0,0 +1,211 @@
+package tinygit
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"math/rand"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// Monta uma árvore com os arquivos informados (caminho com barras →
+// conteúdo), como a salva em um commit
+func testTree(files map[string]string) *Node {
+	blobs := map[string]*Node{}
+	for name, content := range files {
+		p := filepath.FromSlash(name)
+		blobs[p] = &Node{
+			Path:        p,
+			Type:        blobType,
+			Hash:        contentHash(name + "\x00" + content),
+			ContentHash: contentHash(content),
+			Size:        int64(len(content)),
+		}
+	}
+	return treeFromBlobs(blobs)
+}
+
+// Embaralha a ordem dos filhos, que não deve alterar o resultado
+func shuffleTree(node *Node, rnd *rand.Rand) {
+	if node == nil {
+		return
+	}
+	rnd.Shuffle(len(node.Children), func(i, j int) {
+		node.Children[i], node.Children[j] = node.Children[j], node.Children[i]
+	})
+	for _, child := range node.Children {
+		shuffleTree(child, rnd)
+	}
+}
+
+func nodePaths(nodes []*Node) []string {
+	paths := []string{}
+	for _, node := range nodes {
+		paths = append(paths, filepath.ToSlash(node.Path))
+	}
+	return paths
+}
+
+func renamePaths(renames []Rename) []string {
+	paths := []string{}
+	for _, rename := range renames {
+		paths = append(paths, filepath.ToSlash(rename.OldPath)+" -> "+filepath.ToSlash(rename.NewPath))
+	}
+	return paths
+}
+
+func TestCompareTrees(t *testing.T) {
+	tests := []struct {
+		name           string
+		saved, current map[string]string
+		added          []string
+		removed        []string
+		modified       []string
+		renamed        []string
+	}{
+		{
+			name:    "sem alterações",
+			saved:   map[string]string{"a.txt": "a", "dir/b.txt": "b"},
+			current: map[string]string{"a.txt": "a", "dir/b.txt": "b"},
+		},
+		{
+			name:    "repositório vazio",
+			current: map[string]string{"b.txt": "b", "a.txt": "a", "dir/c.txt": "c"},
+			added:   []string{"a.txt", "b.txt", "dir/c.txt"},
+		},
+		{
+			name:     "alterações ordenadas pelo caminho",
+			saved:    map[string]string{"b.txt": "b", "d.txt": "d", "z/x.txt": "x", "z/y.txt": "y"},
+			current:  map[string]string{"a.txt": "a", "b.txt": "b2", "c.txt": "c", "z/x.txt": "x2", "z/w.txt": "w"},
+			added:    []string{"a.txt", "c.txt", "z/w.txt"},
+			removed:  []string{"d.txt", "z/y.txt"},
+			modified: []string{"b.txt", "z/x.txt"},
+		},
+		{
+			name:    "diretório removido",
+			saved:   map[string]string{"a.txt": "a", "dir/b.txt": "b", "dir/sub/c.txt": "c", "dir/sub/d.txt": "d"},
+			current: map[string]string{"a.txt": "a"},
+			removed: []string{"dir/b.txt", "dir/sub/c.txt", "dir/sub/d.txt"},
+		},
+		{
+			name:    "arquivos em um diretório novo",
+			saved:   map[string]string{"a.txt": "a"},
+			current: map[string]string{"a.txt": "a", "new/x.txt": "x", "new/deep/y.txt": "y", "new/deep/er/z.txt": "z"},
+			added:   []string{"new/deep/er/z.txt", "new/deep/y.txt", "new/x.txt"},
+		},
+		{
+			name:    "arquivo substituído por diretório",
+			saved:   map[string]string{"a.txt": "a", "p": "p"},
+			current: map[string]string{"a.txt": "a", "p/q.txt": "q"},
+			added:   []string{"p/q.txt"},
+			removed: []string{"p"},
+		},
+		{
+			name:    "renomeado",
+			saved:   map[string]string{"old/a.txt": "same", "b.txt": "b"},
+			current: map[string]string{"new/a.txt": "same", "b.txt": "b"},
+			renamed: []string{"old/a.txt -> new/a.txt"},
+		},
+	}
+
+	rnd := rand.New(rand.NewSource(1))
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for run := 0; run < 10; run++ {
+				saved, current := testTree(tt.saved), testTree(tt.current)
+				shuffleTree(saved, rnd)
+				shuffleTree(current, rnd)
+
+				c := CompareTrees(saved, current)
+				for _, check := range []struct {
+					field     string
+					got, want []string
+				}{
+					{"Added", nodePaths(c.Added), tt.added},
+					{"Removed", nodePaths(c.Removed), tt.removed},
+					{"Modified", nodePaths(c.Modified), tt.modified},
+					{"Renamed", renamePaths(c.Renamed), tt.renamed},
+				} {
+					want := check.want
+					if want == nil {
+						want = []string{}
+					}
+					if !reflect.DeepEqual(check.got, want) {
+						t.Fatalf("execução %d: %s = %v, esperado %v", run, check.field, check.got, want)
+					}
+				}
+			}
+		})
+	}
+}
+
+// Calcula os hashes dos diretórios como na versão 0, sem os nomes dos filhos
+func legacyHashTree(node *Node) {
+	h := sha1.New()
+	for _, child := range node.Children {
+		if child.Type == treeType {
+			legacyHashTree(child)
+		}
+		io.WriteString(h, child.Hash)
+	}
+	node.Hash = hex.EncodeToString(h.Sum(nil))
+}
+
+func TestUpgradeTreeHash(t *testing.T) {
+	r := newTestRepo(t, testFiles)
+	head := r.Head()
+
+	// Grava o arquivo de versão como uma versão anterior o gravaria
+	v := *r.v
+	legacyHashTree(&v.Tree)
+	v.Head = v.Tree.Hash
+	v.TreeVersion = 0
+	b, err := json.Marshal(&v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := compressVersionFile(filepath.Join(r.path, versionDirName), b); err != nil {
+		t.Fatal(err)
+	}
+	legacyHead := v.Head
+
+	r, err = Open(r.path)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if r.Head() != head {
+		t.Errorf("Head = %s, esperado %s", r.Head(), head)
+	}
+	s, err := r.Status(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !s.Clean {
+		t.Error("diretório de trabalho alterado depois da atualização do hash")
+	}
+	saved, err := decompressVersionFile(r.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.TreeVersion != treeHashVersion {
+		t.Errorf("TreeVersion gravada = %d, esperado %d", saved.TreeVersion, treeHashVersion)
+	}
+
+	// Uma versão assinada com o HEAD antigo continua valendo para a árvore
+	files, err := releaseFiles(r.Tree())
+	if err != nil {
+		t.Fatal(err)
+	}
+	release := &Release{Head: legacyHead, Files: files}
+	if !release.matchesHead(head) {
+		t.Error("versão assinada com o HEAD antigo não reconhecida")
+	}
+	release.Files = release.Files[1:]
+	if release.matchesHead(head) {
+		t.Error("versão assinada com outros arquivos reconhecida")
+	}
+}